@@ -6,5 +6,7 @@ require (
 	github.com/hashicorp/terraform v0.12.28
 	github.com/sethvargo/go-retry v0.1.0
 	github.com/vmware/govmomi v0.23.0
+	golang.org/x/crypto v0.0.0-20200510223506-06a226fb4e37
 	golang.org/x/text v0.3.2
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,129 @@
+package vsphere
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25"
+)
+
+// About describes the vCenter or ESXi host a client is connected to.
+type About struct {
+	Name         string // e.g. "VMware vCenter Server"
+	FullName     string
+	Version      string // product version, e.g. "7.0.1"
+	Build        string
+	APIVersion   string // e.g. "7.0.1.0"
+	APIType      string // "VirtualCenter" or "HostAgent"
+	InstanceUUID string
+	ProductLine  string
+}
+
+// GetAbout reports what c is connected to, from the ServiceContent retrieved at connect time.
+func GetAbout(c *vim25.Client) About {
+
+	a := c.ServiceContent.About
+
+	return About{
+		Name:         a.Name,
+		FullName:     a.FullName,
+		Version:      a.Version,
+		Build:        a.Build,
+		APIVersion:   a.ApiVersion,
+		APIType:      a.ApiType,
+		InstanceUUID: a.InstanceUuid,
+		ProductLine:  a.ProductLineId,
+	}
+}
+
+func (a About) IsVCenter() bool {
+	return a.APIType == "VirtualCenter"
+}
+
+// Feature is an API feature introduced in a given vSphere API version.
+type Feature struct {
+	Name          string
+	MinAPIVersion string
+	VCenterOnly   bool // not available when connected to an ESXi host directly
+}
+
+var (
+	FeatureGuestRegistry = Feature{Name: "guest registry operations", MinAPIVersion: "6.0"}
+	FeatureGuestAliases  = Feature{Name: "guest aliases", MinAPIVersion: "6.0"}
+)
+
+// Supports reports whether f is available on the system a describes.
+func (a About) Supports(f Feature) bool {
+	return a.Require(f) == nil
+}
+
+// Require returns an error matching ErrUnsupported when f is not available on the
+// system a describes. An unknown API version, e.g. of a replayed client, is not checked.
+func (a About) Require(f Feature) error {
+
+	if f.VCenterOnly && !a.IsVCenter() {
+		return &Error{Kind: ErrUnsupported, Err: fmt.Errorf("%s requires vCenter, connected to %s", f.Name, a.describe())}
+	}
+
+	if a.APIVersion != "" && compareVersions(a.APIVersion, f.MinAPIVersion) < 0 {
+		return &Error{Kind: ErrUnsupported, Err: fmt.Errorf("%s requires vSphere API %s or later, connected to %s with API %s", f.Name, f.MinAPIVersion, a.describe(), a.APIVersion)}
+	}
+
+	return nil
+}
+
+// RequireFeature returns an error matching ErrUnsupported unless c supports f.
+func RequireFeature(c *vim25.Client, f Feature) error {
+	return GetAbout(c).Require(f)
+}
+
+func (a About) describe() string {
+
+	name := a.Name
+
+	if name == "" {
+		name = "unknown product"
+	}
+
+	if a.Version != "" {
+		name += " " + a.Version
+	}
+
+	if a.Build != "" {
+		name += " build " + a.Build
+	}
+
+	return name
+}
+
+// compareVersions compares dotted versions numerically, treating missing parts as 0.
+func compareVersions(a, b string) int {
+
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := versionPart(as, i), versionPart(bs, i)
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+
+	if i >= len(parts) {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(strings.TrimSpace(parts[i]))
+
+	return n
+}
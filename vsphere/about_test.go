@@ -0,0 +1,75 @@
+package vsphere_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestGetAbout(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	about := vsphere.GetAbout(s.Client.Client)
+
+	if about.Name != "VMware vCenter Server" || about.APIVersion != "6.5" || !about.IsVCenter() {
+		t.Errorf("got %+v", about)
+	}
+
+	if about.InstanceUUID == "" || about.Build == "" {
+		t.Errorf("instance UUID or build missing: %+v", about)
+	}
+}
+
+func TestRequireFeature(t *testing.T) {
+
+	vc65 := vsphere.About{Name: "VMware vCenter Server", Version: "6.5.0", APIVersion: "6.5", APIType: "VirtualCenter"}
+	esx70 := vsphere.About{Name: "VMware ESXi", Version: "7.0.1", APIVersion: "7.0.1.0", APIType: "HostAgent"}
+
+	tests := []struct {
+		about     vsphere.About
+		feature   vsphere.Feature
+		supported bool
+	}{
+		{vc65, vsphere.FeatureGuestRegistry, true},
+		{vc65, vsphere.Feature{Name: "x", MinAPIVersion: "6.7"}, false},
+		{esx70, vsphere.Feature{Name: "x", MinAPIVersion: "6.7"}, true},
+		{esx70, vsphere.Feature{Name: "x", MinAPIVersion: "6.0", VCenterOnly: true}, false},
+		{vsphere.About{APIVersion: "5.5"}, vsphere.FeatureGuestAliases, false},
+		{vsphere.About{APIVersion: "6.10"}, vsphere.Feature{Name: "x", MinAPIVersion: "6.9"}, true},
+		// unknown version, e.g. a replayed client
+		{vsphere.About{APIType: "VirtualCenter"}, vsphere.FeatureGuestRegistry, true},
+	}
+
+	for _, test := range tests {
+		err := test.about.Require(test.feature)
+
+		if test.supported != (err == nil) {
+			t.Errorf("%s on API %s: got %v", test.feature.Name, test.about.APIVersion, err)
+		}
+
+		if err != nil && !errors.Is(err, vsphere.ErrUnsupported) {
+			t.Errorf("%s on API %s: %v does not match ErrUnsupported", test.feature.Name, test.about.APIVersion, err)
+		}
+	}
+}
+
+func TestRegistryUnsupported(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	// a vCenter without a guest registry manager
+	ops := simulator.Map.Get(*s.Client.ServiceContent.GuestOperationsManager).(*simulator.GuestOperationsManager)
+	ops.GuestWindowsRegistryManager = nil
+
+	_, err := vsphere.NewRegistryManager(context.Background(), s.Client.Client, types.ManagedObjectReference{}, nil)
+
+	if !errors.Is(err, vsphere.ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,277 @@
+package vsphere
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/sts"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// GuestAlias maps a vCenter SSO identity, identified by its signing certificate
+// and subject, to a guest user account.
+type GuestAlias struct {
+	Username   string
+	Base64Cert string
+	Subject    string // SSO subject, e.g. "user@vsphere.local"; empty matches any subject
+	MapCert    bool   // also add the certificate to the guest's global mapping file
+	Comment    string
+}
+
+type GuestAliasManager struct {
+	c    *vim25.Client
+	ref  types.ManagedObjectReference
+	vm   types.ManagedObjectReference
+	auth types.BaseGuestAuthentication
+
+	vmName string // reported in errors
+
+	// DryRun, when set, records changes in the plan instead of making them.
+	DryRun *Plan
+}
+
+func NewGuestAliasManager(ctx context.Context, c *vim25.Client, vm types.ManagedObjectReference, auth types.BaseGuestAuthentication) (*GuestAliasManager, error) {
+
+	if err := RequireFeature(c, FeatureGuestAliases); err != nil {
+		return nil, err
+	}
+
+	g, err := guestOperationsManager(ctx, c, "aliasManager")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if g.AliasManager == nil {
+		return nil, &Error{Kind: ErrUnsupported, Err: fmt.Errorf("guest alias manager is not available on this vCenter")}
+	}
+
+	return &GuestAliasManager{c: c, ref: *g.AliasManager, vm: vm, auth: auth}, nil
+}
+
+func (m *GuestAliasManager) Add(ctx context.Context, alias GuestAlias) error {
+
+	if m.DryRun != nil {
+		m.plan("add alias of %s for subject %q", alias.Username, alias.Subject)
+		return nil
+	}
+
+	req := types.AddGuestAlias{
+		This:       m.ref,
+		Vm:         m.vm,
+		Auth:       m.auth,
+		Username:   alias.Username,
+		MapCert:    alias.MapCert,
+		Base64Cert: alias.Base64Cert,
+		AliasInfo: types.GuestAuthAliasInfo{
+			Subject: aliasSubject(alias.Subject),
+			Comment: alias.Comment,
+		},
+	}
+
+	_, err := methods.AddGuestAlias(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+func (m *GuestAliasManager) List(ctx context.Context, username string) ([]GuestAlias, error) {
+
+	req := types.ListGuestAliases{
+		This:     m.ref,
+		Vm:       m.vm,
+		Auth:     m.auth,
+		Username: username,
+	}
+
+	res, err := methods.ListGuestAliases(ctx, m.c, &req)
+
+	if err != nil {
+		return nil, guestError(m.vmName, err)
+	}
+
+	var aliases []GuestAlias
+
+	for _, cert := range res.Returnval {
+		for _, info := range cert.Aliases {
+			alias := GuestAlias{
+				Username:   username,
+				Base64Cert: cert.Base64Cert,
+				Comment:    info.Comment,
+			}
+
+			if named, ok := info.Subject.(*types.GuestAuthNamedSubject); ok {
+				alias.Subject = named.Name
+			}
+
+			aliases = append(aliases, alias)
+		}
+	}
+
+	return aliases, nil
+}
+
+func (m *GuestAliasManager) Remove(ctx context.Context, alias GuestAlias) error {
+
+	if m.DryRun != nil {
+		m.plan("remove alias of %s for subject %q", alias.Username, alias.Subject)
+		return nil
+	}
+
+	req := types.RemoveGuestAlias{
+		This:       m.ref,
+		Vm:         m.vm,
+		Auth:       m.auth,
+		Username:   alias.Username,
+		Base64Cert: alias.Base64Cert,
+		Subject:    aliasSubject(alias.Subject),
+	}
+
+	_, err := methods.RemoveGuestAlias(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+// RemoveByCert removes every alias of username that uses base64Cert.
+func (m *GuestAliasManager) RemoveByCert(ctx context.Context, username, base64Cert string) error {
+
+	if m.DryRun != nil {
+		m.plan("remove aliases of %s for certificate", username)
+		return nil
+	}
+
+	req := types.RemoveGuestAliasByCert{
+		This:       m.ref,
+		Vm:         m.vm,
+		Auth:       m.auth,
+		Username:   username,
+		Base64Cert: base64Cert,
+	}
+
+	_, err := methods.RemoveGuestAliasByCert(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+func AddGuestAlias(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, alias GuestAlias, options map[string]interface{}) error {
+
+	m, tboxClient, err := guestAliasManager(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return err
+	}
+
+	defer tboxClient.ReleaseCredentials(ctx)
+
+	return m.Add(ctx, alias)
+}
+
+func ListGuestAliases(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, username string, options map[string]interface{}) ([]GuestAlias, error) {
+
+	m, tboxClient, err := guestAliasManager(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tboxClient.ReleaseCredentials(ctx)
+
+	return m.List(ctx, username)
+}
+
+func RemoveGuestAlias(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, alias GuestAlias, options map[string]interface{}) error {
+
+	m, tboxClient, err := guestAliasManager(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return err
+	}
+
+	defer tboxClient.ReleaseCredentials(ctx)
+
+	return m.Remove(ctx, alias)
+}
+
+// IssueGuestToken requests a holder-of-key SAML token from the vCenter STS for the
+// solution user identified by cert. Pass the token to WithSAMLToken (or the "samlToken"
+// option) together with the guest user of an alias registered for cert.
+func IssueGuestToken(ctx context.Context, c *vim25.Client, cert *tls.Certificate, lifetime time.Duration) (string, error) {
+	return issueToken(ctx, c, sts.TokenRequest{
+		Certificate: cert,
+		Lifetime:    lifetime,
+		Delegatable: true,
+	})
+}
+
+// IssueGuestBearerToken requests a bearer SAML token for an SSO user.
+func IssueGuestBearerToken(ctx context.Context, c *vim25.Client, ssoUser, ssoPassword string, lifetime time.Duration) (string, error) {
+	return issueToken(ctx, c, sts.TokenRequest{
+		Userinfo:    url.UserPassword(ssoUser, ssoPassword),
+		Lifetime:    lifetime,
+		Delegatable: true,
+	})
+}
+
+func issueToken(ctx context.Context, c *vim25.Client, req sts.TokenRequest) (string, error) {
+
+	signer, err := issueSigner(ctx, c, req)
+
+	if err != nil {
+		return "", err
+	}
+
+	return signer.Token, nil
+}
+
+func issueSigner(ctx context.Context, c *vim25.Client, req sts.TokenRequest) (*sts.Signer, error) {
+
+	stsClient, err := sts.NewClient(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stsClient.Issue(ctx, req)
+}
+
+func guestAliasManager(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, options map[string]interface{}) (*GuestAliasManager, *ToolBoxClient, error) {
+
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+		tboxClient.ReleaseCredentials(ctx)
+		return nil, nil, err
+	}
+
+	m, err := NewGuestAliasManager(ctx, c.Client, vm.Reference(), tboxClient.Authentication)
+
+	if err != nil {
+		tboxClient.ReleaseCredentials(ctx)
+		return nil, nil, err
+	}
+
+	m.vmName = vmName
+	m.DryRun = tboxClient.DryRun
+
+	return m, tboxClient, nil
+}
+
+func (m *GuestAliasManager) plan(format string, args ...interface{}) {
+	m.DryRun.add(PlannedStep{Kind: "alias", VM: m.vm.Value, Description: fmt.Sprintf(format, args...)})
+}
+
+func aliasSubject(subject string) types.BaseGuestAuthSubject {
+	if subject == "" {
+		return &types.GuestAuthAnySubject{}
+	}
+	return &types.GuestAuthNamedSubject{Name: subject}
+}
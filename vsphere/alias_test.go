@@ -0,0 +1,93 @@
+package vsphere_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestGuestAliases(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	ctx := context.Background()
+
+	anyone := vsphere.GuestAlias{Username: "svc", Base64Cert: "cert", Comment: "any subject"}
+	named := vsphere.GuestAlias{Username: "svc", Base64Cert: "cert", Subject: "user@vsphere.local", Comment: "named"}
+
+	for _, alias := range []vsphere.GuestAlias{anyone, named} {
+		if err := vsphere.AddGuestAlias(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", alias, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliases, err := vsphere.ListGuestAliases(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", "svc", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 2 || aliases[0] != anyone || aliases[1] != named {
+		t.Errorf("got aliases %+v, want %+v and %+v", aliases, anyone, named)
+	}
+
+	if err := vsphere.RemoveGuestAlias(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", named, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err = vsphere.ListGuestAliases(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", "svc", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0] != anyone {
+		t.Errorf("got aliases %+v after removing %q, want %+v", aliases, named.Subject, anyone)
+	}
+
+	if err := vsphere.RemoveGuestAlias(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", named, nil); err == nil {
+		t.Error("removed a missing alias")
+	}
+}
+
+func TestGuestAliasesRemoveByCert(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	m, err := vsphere.NewGuestAliasManager(ctx, s.Client.Client, s.VirtualMachine("DC0_H0_VM0").Reference(),
+		&types.NamePasswordAuthentication{Username: "admin", Password: "secret"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alias := range []vsphere.GuestAlias{
+		{Username: "svc", Base64Cert: "old", Subject: "a@vsphere.local"},
+		{Username: "svc", Base64Cert: "old", Subject: "b@vsphere.local"},
+		{Username: "svc", Base64Cert: "new"},
+	} {
+		if err := m.Add(ctx, alias); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.RemoveByCert(ctx, "svc", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := m.List(ctx, "svc")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(aliases) != 1 || aliases[0].Base64Cert != "new" {
+		t.Errorf("got aliases %+v, want only the new certificate's", aliases)
+	}
+}
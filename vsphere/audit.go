@@ -0,0 +1,186 @@
+package vsphere
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// AuditRecord describes one guest operation. Commands and scripts are recorded
+// by their SHA-256 hash only, so the audit log never contains their text.
+type AuditRecord struct {
+	Operation       string    `json:"operation"`
+	VCenter         string    `json:"vcenter,omitempty"`
+	VM              string    `json:"vm,omitempty"`
+	VMMoref         string    `json:"vmMoref,omitempty"`
+	GuestUser       string    `json:"guestUser,omitempty"`
+	SHA256          string    `json:"sha256,omitempty"`
+	Source          string    `json:"source,omitempty"`
+	Destination     string    `json:"destination,omitempty"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	ExitCode        *int      `json:"exitCode,omitempty"`
+	BytesUploaded   int64     `json:"bytesUploaded"`
+	BytesDownloaded int64     `json:"bytesDownloaded"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// Auditor writes one JSON record per line and is safe for concurrent use.
+type Auditor struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+func NewAuditor(w io.Writer) *Auditor {
+	return &Auditor{enc: json.NewEncoder(w)}
+}
+
+// OpenAuditFile appends audit records to the file at path, creating it if needed.
+func OpenAuditFile(path string) (*Auditor, error) {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a := NewAuditor(f)
+	a.closer = f
+
+	return a, nil
+}
+
+func (a *Auditor) Record(rec *AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.enc.Encode(rec)
+}
+
+func (a *Auditor) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+var (
+	auditorMu sync.RWMutex
+	auditor   *Auditor
+)
+
+// SetAuditor records every guest operation of the package to a; nil disables auditing.
+// A ToolBoxClient's own Auditor, or the "audit" option, takes precedence.
+func SetAuditor(a *Auditor) {
+	auditorMu.Lock()
+	defer auditorMu.Unlock()
+
+	auditor = a
+}
+
+func (c *ToolBoxClient) startAudit(op string) *AuditRecord {
+
+	rec := &AuditRecord{
+		Operation: op,
+		VCenter:   c.VCenter,
+		VM:        c.VMName,
+		Start:     time.Now(),
+	}
+
+	if c.VM.Value != "" {
+		rec.VMMoref = c.VM.Value
+	}
+
+	switch auth := c.Authentication.(type) {
+	case *types.NamePasswordAuthentication:
+		rec.GuestUser = auth.Username
+	case *types.SAMLTokenAuthentication:
+		rec.GuestUser = auth.Username
+	}
+
+	return rec
+}
+
+func (c *ToolBoxClient) finishAudit(rec *AuditRecord, err error) {
+
+	a := c.Auditor
+
+	if a == nil {
+		auditorMu.RLock()
+		a = auditor
+		auditorMu.RUnlock()
+	}
+
+	if a == nil {
+		return
+	}
+
+	rec.End = time.Now()
+
+	var exit *ExitError
+
+	if errors.As(err, &exit) {
+		code := exit.ExitCode()
+		rec.ExitCode = &code
+	}
+
+	if err != nil {
+		rec.Error = DefaultRedactor.Redact(err.Error())
+	}
+
+	if err := a.Record(rec); err != nil {
+		logWarn("writing audit record failed", "vm", c.VMName, "error", err)
+	}
+}
+
+func hashContent(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// sizedUpload works out the Content-Length of r the way toolbox.Client.Upload does,
+// buffering readers of unknown size, so the upload can be accounted for.
+func sizedUpload(r io.Reader) (io.Reader, soap.Upload, error) {
+
+	p := soap.DefaultUpload
+
+	switch r := r.(type) {
+	case *bytes.Buffer:
+		p.ContentLength = int64(r.Len())
+	case *bytes.Reader:
+		p.ContentLength = int64(r.Len())
+	case *strings.Reader:
+		p.ContentLength = int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil {
+			return nil, p, err
+		}
+		p.ContentLength = info.Size()
+	}
+
+	if p.ContentLength == 0 {
+		buf := new(bytes.Buffer)
+
+		n, err := io.Copy(buf, r)
+		if err != nil {
+			return nil, p, err
+		}
+
+		p.ContentLength = n
+		r = buf
+	}
+
+	return r, p, nil
+}
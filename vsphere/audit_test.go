@@ -0,0 +1,136 @@
+package vsphere_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/guest"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func auditRecords(t *testing.T, buf *bytes.Buffer) []vsphere.AuditRecord {
+	t.Helper()
+
+	var recs []vsphere.AuditRecord
+
+	dec := json.NewDecoder(buf)
+
+	for dec.More() {
+		var rec vsphere.AuditRecord
+
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+
+		recs = append(recs, rec)
+	}
+
+	return recs
+}
+
+// toolBoxClient connects a ToolBoxClient to the guest of vmName in s.
+func toolBoxClient(t *testing.T, s *vspheretest.Simulator, vmName string) *vsphere.ToolBoxClient {
+	t.Helper()
+
+	ctx := context.Background()
+
+	vm, err := find.NewFinder(s.Client.Client).VirtualMachine(ctx, vmName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := vsphere.NewToolBoxClient(ctx, guest.NewOperationsManager(s.Client.Client, vm.Reference()),
+		"admin", "secret", types.VirtualMachineGuestOsFamilyWindowsGuest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.VM = vm.Reference()
+	c.VMName = vmName
+
+	return c
+}
+
+func TestAuditUploadDirectory(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	var buf bytes.Buffer
+
+	err := vsphere.Upload(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		bytes.NewReader([]byte("archive")), ".tar.gz", `C:\app`, true, map[string]interface{}{"audit": vsphere.NewAuditor(&buf)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(g.Processes()); n != 2 {
+		t.Errorf("ran %d extraction commands, want 2", n)
+	}
+
+	recs := auditRecords(t, &buf)
+
+	if len(recs) != 1 || recs[0].Operation != "upload" || recs[0].Destination != `C:\app` || recs[0].BytesUploaded != 7 {
+		t.Errorf("got audit records %+v, want one upload", recs)
+	}
+}
+
+func TestAuditDownload(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+	g.WriteFile(`C:\app\log.txt`, []byte("log content"))
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	c := toolBoxClient(t, s, "DC0_H0_VM0")
+
+	var buf bytes.Buffer
+	c.Auditor = vsphere.NewAuditor(&buf)
+
+	f, _, err := c.Download(context.Background(), `C:\app\log.txt`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(f)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "log content" {
+		t.Errorf("got %q", data)
+	}
+
+	if buf.Len() != 0 {
+		t.Error("download audited before it was closed")
+	}
+
+	f.Close()
+	f.Close()
+
+	recs := auditRecords(t, &buf)
+
+	if len(recs) != 1 || recs[0].Operation != "download" || recs[0].Source != `C:\app\log.txt` || recs[0].BytesDownloaded != 11 {
+		t.Errorf("got audit records %+v, want one download", recs)
+	}
+
+	if _, _, err := c.Download(context.Background(), `C:\app\missing.txt`); err == nil {
+		t.Error("downloading a missing file succeeded")
+	}
+
+	if recs := auditRecords(t, &buf); len(recs) != 1 || recs[0].Error == "" {
+		t.Errorf("got audit records %+v, want the failed download", recs)
+	}
+}
@@ -2,19 +2,280 @@ package vsphere
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
 	"github.com/vmware/govmomi/vim25/soap"
-	"net/url"
 )
 
+// NewClient logs in after verifying the vCenter certificate against the system roots.
+// Use NewClientWithConfig to trust a CA bundle or pinned thumbprints instead, or to skip
+// verification with Insecure.
 func NewClient(ctx context.Context, vSphereHost, vSphereUsername, vSpherePassword string) (*govmomi.Client, error) {
+	return NewClientWithConfig(ctx, ClientConfig{URL: vSphereHost, Username: vSphereUsername, Password: vSpherePassword})
+}
+
+// ClientConfig describes how to connect and log in to vCenter. By default the
+// certificate is verified against the system roots.
+type ClientConfig struct {
+	URL      string
+	Username string
+	Password string
+
+	// Insecure skips certificate verification for hosts without a pinned thumbprint.
+	Insecure bool
+
+	// CAFile is a PEM bundle, or a list of them separated like $PATH, trusted instead of the system roots.
+	CAFile string
+
+	// Thumbprints pins hosts, as "host" or "host:port", to the SHA-1 or SHA-256 thumbprint of
+	// their certificate in hex, with or without colons. A pinned certificate is accepted even if
+	// it is self-signed; any other certificate is rejected. This covers the ESXi hosts guest
+	// file transfers connect to as well.
+	Thumbprints map[string]string
+
+	// Token, when set, logs in with this SAML bearer token, or holder-of-key token for
+	// Certificate, instead of a password. See IssueToken to reuse it for guest operations.
+	Token string
+
+	// Certificate logs in as the solution user of this certificate with a holder-of-key
+	// token from the vCenter STS; no username or password is needed.
+	Certificate *tls.Certificate
+
+	// SSOToken logs in with a bearer token issued by the vCenter STS for Username and
+	// Password instead of a password login, as some SSO policies require.
+	SSOToken bool
+
+	// TokenLifetime is the lifetime of issued tokens, 5 minutes by default.
+	TokenLifetime time.Duration
+
+	// SessionCache reuses the session saved in SessionDir, $HOME/.govmomi/sessions by default,
+	// keyed by URL and user like govc does, instead of logging in on every connect.
+	SessionCache bool
+	SessionDir   string
+
+	// KeepAlive pings the session at this interval so it does not idle out; 0 disables it.
+	KeepAlive time.Duration
+
+	// Record appends every SOAP call and guest file transfer to this file, for Replay.
+	// Close it with CloseRecording when done with the client.
+	Record string
+
+	// Replay serves the calls recorded in this file instead of contacting vCenter; URL
+	// defaults to the recorded one. Keep-alive is not replayed.
+	Replay string
+
+	replayer  *Replayer
+	recording *recording
+}
+
+// NewClientWithConfig connects to cfg.URL with the TLS settings of cfg and logs in
+// when a username is given. See ClientConfig for session caching and keep-alive.
+func NewClientWithConfig(ctx context.Context, cfg ClientConfig) (*govmomi.Client, error) {
+
+	if cfg.Replay != "" {
+		replayer, err := LoadReplay(cfg.Replay)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if cfg.URL == "" {
+			cfg.URL = replayer.URL()
+		}
+
+		cfg.replayer = replayer
+		cfg.KeepAlive = 0
+	}
+
+	u, err := soap.ParseURL(cfg.URL)
 
-	u, err := soap.ParseURL(vSphereHost)
 	if err != nil {
 		return nil, err
 	}
 
-	u.User = url.UserPassword(vSphereUsername, vSpherePassword)
+	if u == nil {
+		return nil, fmt.Errorf("vCenter URL is empty")
+	}
+
+	if cfg.Username != "" {
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	if cfg.Record != "" && cfg.replayer == nil {
+		rec, err := openRecording(cfg.Record)
+
+		if err != nil {
+			logWarn("recording disabled", "file", cfg.Record, "error", err)
+		} else {
+			cfg.recording = rec
+		}
+	}
+
+	var vc *vim25.Client
+	var save func() error
+
+	if cfg.SessionCache {
+		vc, save, err = cachedLogin(ctx, u, cfg)
+	} else {
+		vc, err = newVim25Client(ctx, u, cfg)
+		if err == nil {
+			err = cfg.login(ctx, vc)
+		}
+	}
+
+	if err != nil {
+		if cfg.recording != nil {
+			_ = cfg.recording.Close()
+		}
+		return nil, err
+	}
+
+	keepSession(vc, cfg, save)
+
+	return &govmomi.Client{
+		Client:         vc,
+		SessionManager: session.NewManager(vc),
+	}, nil
+}
+
+func newVim25Client(ctx context.Context, u *url.URL, cfg ClientConfig) (*vim25.Client, error) {
+
+	sc := soap.NewClient(u, cfg.Insecure)
+
+	if err := configureTransport(sc, cfg); err != nil {
+		return nil, err
+	}
+
+	return vim25.NewClient(ctx, sc)
+}
+
+// configureTransport applies the CA bundle, thumbprint pins and recording or replay of cfg to sc.
+func configureTransport(sc *soap.Client, cfg ClientConfig) error {
+
+	if cfg.replayer != nil {
+		sc.Client.Transport = cfg.replayer
+		return nil
+	}
+
+	if cfg.recording != nil {
+		defer recordTo(sc, cfg.recording)
+	}
+
+	if cfg.CAFile != "" {
+		if err := sc.SetRootCAs(cfg.CAFile); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.Thumbprints) != 0 {
+		pins, err := parseThumbprints(cfg.Thumbprints)
+
+		if err != nil {
+			return err
+		}
+
+		t := sc.DefaultTransport()
+		t.DialTLS = pinningDialer(t, pins)
+	}
+
+	return nil
+}
+
+// pinningDialer verifies hosts in pins by thumbprint and leaves all others to the transport's own dialer.
+func pinningDialer(t *http.Transport, pins map[string]string) func(network, addr string) (net.Conn, error) {
+
+	base := t.TLSClientConfig
+	dial := t.DialTLS
+
+	return func(network, addr string) (net.Conn, error) {
+
+		pin, ok := pins[hostAddr(addr)]
+
+		if !ok {
+			if dial != nil {
+				return dial(network, addr)
+			}
+			return tls.Dial(network, addr, base)
+		}
+
+		config := base.Clone()
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("%w: host %s presented no certificate", ErrCertificateMismatch, addr)
+			}
+			return checkThumbprint(addr, pin, rawCerts[0])
+		}
+
+		return tls.Dial(network, addr, config)
+	}
+}
+
+func checkThumbprint(addr, pin string, cert []byte) error {
+
+	sha1Sum := sha1.Sum(cert)
+	sha256Sum := sha256.Sum256(cert)
+
+	presented := hex.EncodeToString(sha256Sum[:])
+
+	if len(pin) == 2*sha1.Size {
+		presented = hex.EncodeToString(sha1Sum[:])
+	}
+
+	if strings.EqualFold(pin, presented) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: host %s presented thumbprint %s, expected %s",
+		ErrCertificateMismatch, addr, formatThumbprint(presented), formatThumbprint(pin))
+}
+
+func parseThumbprints(thumbprints map[string]string) (map[string]string, error) {
+
+	pins := make(map[string]string, len(thumbprints))
+
+	for host, thumbprint := range thumbprints {
+		pin := strings.ToLower(strings.Replace(strings.TrimSpace(thumbprint), ":", "", -1))
+
+		if _, err := hex.DecodeString(pin); err != nil || (len(pin) != 2*sha1.Size && len(pin) != 2*sha256.Size) {
+			return nil, fmt.Errorf("thumbprint %q for %s is neither a SHA-1 nor a SHA-256 thumbprint", thumbprint, host)
+		}
+
+		pins[hostAddr(host)] = pin
+	}
+
+	return pins, nil
+}
+
+// hostAddr adds the default https port to host.
+func hostAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(strings.Trim(host, "[]"), "443")
+	}
+	return host
+}
+
+// formatThumbprint renders a hex thumbprint as colon separated upper case pairs, like vCenter shows it.
+func formatThumbprint(pin string) string {
+
+	pairs := make([]string, 0, len(pin)/2)
+
+	for i := 0; i+1 < len(pin); i += 2 {
+		pairs = append(pairs, strings.ToUpper(pin[i:i+2]))
+	}
 
-	return govmomi.NewClient(ctx, u, true)
-}
\ No newline at end of file
+	return strings.Join(pairs, ":")
+}
@@ -0,0 +1,151 @@
+package vsphere_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+// startTLS starts a simulator serving https and returns its URL without credentials.
+func startTLS(t *testing.T) (*vspheretest.Simulator, string) {
+	t.Helper()
+
+	s := vspheretest.Start(t, vspheretest.Options{TLS: true})
+
+	u := s.URL()
+	u.User = nil
+
+	return s, u.String()
+}
+
+func TestNewClientVerifiesCertificate(t *testing.T) {
+
+	_, u := startTLS(t)
+
+	if _, err := vsphere.NewClient(context.Background(), u, "user", "pass"); err == nil {
+		t.Fatal("self-signed certificate accepted")
+	}
+
+	c, err := vsphere.NewClientWithConfig(context.Background(), vsphere.ClientConfig{URL: u, Username: "user", Password: "pass", Insecure: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = c.Logout(context.Background())
+}
+
+func TestClientConfigCAFile(t *testing.T) {
+
+	s, u := startTLS(t)
+
+	ca, err := s.Server.CertificateFile()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := vsphere.NewClientWithConfig(context.Background(), vsphere.ClientConfig{URL: u, Username: "user", Password: "pass", CAFile: ca})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = c.Logout(context.Background())
+}
+
+func TestClientConfigThumbprints(t *testing.T) {
+
+	s, u := startTLS(t)
+
+	raw := s.Server.Certificate().Raw
+	sha1Sum := sha1.Sum(raw)
+	sha256Sum := sha256.Sum256(raw)
+
+	host := s.URL().Host
+
+	for _, pin := range []string{
+		s.Server.CertificateInfo().ThumbprintSHA1, // colon separated, as vCenter shows it
+		hex.EncodeToString(sha1Sum[:]),
+		strings.ToUpper(hex.EncodeToString(sha256Sum[:])),
+	} {
+		c, err := vsphere.NewClientWithConfig(context.Background(), vsphere.ClientConfig{
+			URL:         u,
+			Username:    "user",
+			Password:    "pass",
+			Thumbprints: map[string]string{host: pin},
+		})
+
+		if err != nil {
+			t.Errorf("pin %s: %v", pin, err)
+			continue
+		}
+
+		_ = c.Logout(context.Background())
+	}
+}
+
+func TestClientConfigThumbprintMismatch(t *testing.T) {
+
+	s, u := startTLS(t)
+
+	wrong := strings.Repeat("ab", sha256.Size)
+
+	_, err := vsphere.NewClientWithConfig(context.Background(), vsphere.ClientConfig{
+		URL:         u,
+		Username:    "user",
+		Password:    "pass",
+		Insecure:    true, // a pin takes precedence
+		Thumbprints: map[string]string{s.URL().Host: wrong},
+	})
+
+	if !errors.Is(err, vsphere.ErrCertificateMismatch) {
+		t.Fatalf("got %v, want ErrCertificateMismatch", err)
+	}
+
+	sum := sha256.Sum256(s.Server.Certificate().Raw)
+
+	want := fmt.Sprintf("host %s presented thumbprint %s, expected %s",
+		s.URL().Host, colonHex(sum[:]), strings.TrimSuffix(strings.Repeat("AB:", sha256.Size), ":"))
+
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("got %q, want it to contain %q", err, want)
+	}
+}
+
+func TestClientConfigBadThumbprint(t *testing.T) {
+
+	for _, pin := range []string{"abc", strings.Repeat("zz", sha1.Size), strings.Repeat("ab", 24)} {
+		_, err := vsphere.NewClientWithConfig(context.Background(), vsphere.ClientConfig{
+			URL:         "https://vcenter.example.com/sdk",
+			Thumbprints: map[string]string{"vcenter.example.com": pin},
+		})
+
+		if err == nil || !strings.Contains(err.Error(), "neither a SHA-1 nor a SHA-256 thumbprint") {
+			t.Errorf("pin %s: got %v", pin, err)
+		}
+
+		var uerr *url.Error
+
+		if errors.As(err, &uerr) {
+			t.Errorf("pin %s: tried to connect", pin)
+		}
+	}
+}
+
+func colonHex(b []byte) string {
+	pairs := make([]string, len(b))
+	for i := range b {
+		pairs[i] = fmt.Sprintf("%02X", b[i])
+	}
+	return strings.Join(pairs, ":")
+}
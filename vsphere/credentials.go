@@ -0,0 +1,457 @@
+package vsphere
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/crypto/scrypt"
+)
+
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialProvider returns the candidate credentials for target, a VM name or
+// vCenter URL, in the order they should be tried.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, target string) ([]Credentials, error)
+}
+
+// StaticCredentials always returns the same account.
+type StaticCredentials Credentials
+
+func (s StaticCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+	return []Credentials{Credentials(s)}, nil
+}
+
+// EnvCredentials reads an account from environment variables.
+type EnvCredentials struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+func (e EnvCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+
+	username, ok := os.LookupEnv(e.UsernameVar)
+
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", e.UsernameVar)
+	}
+
+	password, ok := os.LookupEnv(e.PasswordVar)
+
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", e.PasswordVar)
+	}
+
+	return []Credentials{{Username: username, Password: password}}, nil
+}
+
+// CredentialFile is the JSON layout read by FileCredentials and EncryptedFileCredentials.
+// Targets keys may be path.Match patterns such as "web-*"; Default accounts are tried last.
+type CredentialFile struct {
+	Default []Credentials            `json:"default,omitempty"`
+	Targets map[string][]Credentials `json:"targets,omitempty"`
+}
+
+func (f *CredentialFile) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+
+	creds := append([]Credentials(nil), f.Targets[target]...)
+
+	var patterns []string
+	for pattern := range f.Targets {
+		if pattern != target {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, target); ok {
+			creds = append(creds, f.Targets[pattern]...)
+		}
+	}
+
+	creds = append(creds, f.Default...)
+
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("no credentials for %s", target)
+	}
+
+	return creds, nil
+}
+
+// FileCredentials reads a CredentialFile in JSON, or a netrc-style file with
+// "machine <target> login <user> password <password>" and "default" entries.
+type FileCredentials struct {
+	Path string
+}
+
+func (p FileCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+
+	b, err := ioutil.ReadFile(p.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parseCredentialFile(b)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p.Path, err)
+	}
+
+	return f.Credentials(ctx, target)
+}
+
+// EncryptedFileCredentials reads a CredentialFile written by WriteEncryptedCredentials.
+type EncryptedFileCredentials struct {
+	Path       string
+	Passphrase string
+}
+
+func (p EncryptedFileCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+
+	b, err := ioutil.ReadFile(p.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := decryptCredentials(b, p.Passphrase)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", p.Path, err)
+	}
+
+	var f CredentialFile
+
+	if err := json.Unmarshal(plain, &f); err != nil {
+		return nil, fmt.Errorf("%s: %s", p.Path, err)
+	}
+
+	return f.Credentials(ctx, target)
+}
+
+// CommandCredentials runs an external command with the target as last argument.
+// It must print a JSON object or array of objects with username and password.
+type CommandCredentials struct {
+	Command string
+	Args    []string
+}
+
+func (p CommandCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, p.Command, append(p.Args, target)...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s %s", p.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	out = bytes.TrimSpace(out)
+
+	var creds []Credentials
+
+	if bytes.HasPrefix(out, []byte("[")) {
+		err = json.Unmarshal(out, &creds)
+	} else {
+		var c Credentials
+		err = json.Unmarshal(out, &c)
+		creds = []Credentials{c}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid output: %s", p.Command, err)
+	}
+
+	return creds, nil
+}
+
+// ChainCredentials concatenates the candidates of each provider, skipping providers that fail.
+type ChainCredentials []CredentialProvider
+
+func (chain ChainCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+
+	var creds []Credentials
+	var errs []string
+
+	for _, p := range chain {
+		c, err := p.Credentials(ctx, target)
+
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		creds = append(creds, c...)
+	}
+
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("no credentials for %s: %s", target, strings.Join(errs, "; "))
+	}
+
+	return creds, nil
+}
+
+// UseCredentials tries each candidate from provider in order and keeps the first
+// one the guest accepts. It only replaces name and password authentication; clients
+// using a SAML token, a ticket or acquired credentials are left unchanged. Errors other
+// than the guest rejecting a candidate are returned without trying the next one.
+func (c *ToolBoxClient) UseCredentials(ctx context.Context, provider CredentialProvider, target string) (*Credentials, error) {
+
+	current, ok := c.Authentication.(*types.NamePasswordAuthentication)
+
+	if !ok || c.acquired {
+		return nil, fmt.Errorf("cannot fall back to other accounts from %T guest authentication", c.Authentication)
+	}
+
+	candidates, err := provider.Credentials(ctx, target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no credentials for %s", target)
+	}
+
+	interactive := current.InteractiveSession
+
+	for i := range candidates {
+		c.Authentication = &types.NamePasswordAuthentication{
+			GuestAuthentication: types.GuestAuthentication{
+				InteractiveSession: interactive,
+			},
+			Username: candidates[i].Username,
+			Password: candidates[i].Password,
+		}
+
+		if err = c.TestCredentials(ctx); err == nil {
+			return &candidates[i], nil
+		}
+
+		// only a rejected account falls back to the next one
+		if faultKind(err) != ErrGuestAuth {
+			c.Authentication = current
+			return nil, err
+		}
+	}
+
+	c.Authentication = current
+
+	return nil, fmt.Errorf("none of %d accounts for %s were accepted: %w", len(candidates), target, err)
+}
+
+// NewClientWithCredentials logs in to vCenter with the first account from provider that succeeds.
+// Only accounts vCenter rejects fall back to the next one; other errors are returned as they are.
+func NewClientWithCredentials(ctx context.Context, vSphereHost string, provider CredentialProvider) (*govmomi.Client, error) {
+
+	candidates, err := provider.Credentials(ctx, vSphereHost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no credentials for %s", vSphereHost)
+	}
+
+	for _, cred := range candidates {
+		var c *govmomi.Client
+
+		c, err = NewClient(ctx, vSphereHost, cred.Username, cred.Password)
+
+		if err == nil {
+			return c, nil
+		}
+
+		// connection and certificate errors are the same for every account
+		if !isInvalidLogin(err) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("none of %d accounts for %s were accepted: %w", len(candidates), vSphereHost, err)
+}
+
+func parseCredentialFile(b []byte) (*CredentialFile, error) {
+
+	var f CredentialFile
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, err
+		}
+		return &f, nil
+	}
+
+	f.Targets = make(map[string][]Credentials)
+
+	var machine string
+	var cred *Credentials
+
+	flush := func() {
+		if cred == nil {
+			return
+		}
+		if machine == "" {
+			f.Default = append(f.Default, *cred)
+		} else {
+			f.Targets[machine] = append(f.Targets[machine], *cred)
+		}
+		cred = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Split(bufio.ScanWords)
+
+	for scanner.Scan() {
+		switch token := scanner.Text(); token {
+		case "machine":
+			flush()
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("machine without a name")
+			}
+			machine = scanner.Text()
+			cred = new(Credentials)
+		case "default":
+			flush()
+			machine = ""
+			cred = new(Credentials)
+		case "login", "password":
+			if cred == nil || !scanner.Scan() {
+				return nil, fmt.Errorf("unexpected %q", token)
+			}
+			if token == "login" {
+				cred.Username = scanner.Text()
+			} else {
+				cred.Password = scanner.Text()
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q", token)
+		}
+	}
+
+	flush()
+
+	return &f, scanner.Err()
+}
+
+const credentialMagic = "govsphere-cred-v1\n"
+
+// WriteEncryptedCredentials encrypts f with a key derived from passphrase
+// (scrypt, AES-256-GCM) in the format read by EncryptedFileCredentials.
+func WriteEncryptedCredentials(w io.Writer, passphrase string, f *CredentialFile) error {
+
+	plain, err := json.Marshal(f)
+
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	gcm, err := credentialCipher(passphrase, salt)
+
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	out := append([]byte(credentialMagic), salt...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, plain, []byte(credentialMagic))
+
+	_, err = w.Write(out)
+
+	return err
+}
+
+func decryptCredentials(b []byte, passphrase string) ([]byte, error) {
+
+	if !bytes.HasPrefix(b, []byte(credentialMagic)) {
+		return nil, fmt.Errorf("not an encrypted credential file")
+	}
+
+	b = b[len(credentialMagic):]
+
+	if len(b) < 16 {
+		return nil, fmt.Errorf("encrypted credential file is truncated")
+	}
+
+	gcm, err := credentialCipher(passphrase, b[:16])
+
+	if err != nil {
+		return nil, err
+	}
+
+	b = b[16:]
+
+	if len(b) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted credential file is truncated")
+	}
+
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(credentialMagic))
+
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted file")
+	}
+
+	return plain, nil
+}
+
+func credentialCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// isInvalidLogin reports whether err is vCenter rejecting the username or password.
+func isInvalidLogin(err error) bool {
+	switch vimFault(err).(type) {
+	case types.InvalidLogin, *types.InvalidLogin:
+		return true
+	}
+	return false
+}
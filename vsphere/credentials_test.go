@@ -0,0 +1,267 @@
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi/guest/toolbox"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// tempDir is t.TempDir, which needs Go 1.15.
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "vsphere")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}
+
+func TestParseCredentialFile(t *testing.T) {
+
+	netrc := `
+machine web-01 login admin password s3cret
+machine web-* login ops password ops-pass
+default login Administrator password fallback
+`
+
+	f, err := parseCredentialFile([]byte(netrc))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &CredentialFile{
+		Default: []Credentials{{"Administrator", "fallback"}},
+		Targets: map[string][]Credentials{
+			"web-01": {{"admin", "s3cret"}},
+			"web-*":  {{"ops", "ops-pass"}},
+		},
+	}
+
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("netrc: got %+v, want %+v", f, want)
+	}
+
+	js := `{"default": [{"username": "Administrator", "password": "fallback"}], "targets": {"web-01": [{"username": "admin", "password": "s3cret"}]}}`
+
+	f, err = parseCredentialFile([]byte(js))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Default) != 1 || f.Targets["web-01"][0].Password != "s3cret" {
+		t.Errorf("json: got %+v", f)
+	}
+
+	for _, bad := range []string{"machine", "login admin", "default login", "host web-01"} {
+		if _, err := parseCredentialFile([]byte(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestCredentialFileOrder(t *testing.T) {
+
+	f := &CredentialFile{
+		Default: []Credentials{{Username: "default"}},
+		Targets: map[string][]Credentials{
+			"web-01": {{Username: "exact"}},
+			"web-*":  {{Username: "pattern"}},
+			"w*":     {{Username: "wide"}},
+			"db-*":   {{Username: "other"}},
+		},
+	}
+
+	creds, err := f.Credentials(context.Background(), "web-01")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := usernames(creds); got != "exact wide pattern default" {
+		t.Errorf("got %s", got)
+	}
+
+	if _, err := new(CredentialFile).Credentials(context.Background(), "web-01"); err == nil {
+		t.Error("empty file: no error")
+	}
+}
+
+func TestEncryptedCredentials(t *testing.T) {
+
+	f := &CredentialFile{Targets: map[string][]Credentials{"web-01": {{"admin", "s3cret"}}}}
+
+	var buf bytes.Buffer
+
+	if err := WriteEncryptedCredentials(&buf, "passphrase", f); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("s3cret")) {
+		t.Fatal("password written in clear text")
+	}
+
+	path := filepath.Join(tempDir(t), "credentials")
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := EncryptedFileCredentials{Path: path, Passphrase: "passphrase"}.Credentials(context.Background(), "web-01")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(creds) != 1 || creds[0] != (Credentials{"admin", "s3cret"}) {
+		t.Errorf("got %+v", creds)
+	}
+
+	_, err = EncryptedFileCredentials{Path: path, Passphrase: "wrong"}.Credentials(context.Background(), "web-01")
+
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+}
+
+func TestChainCredentials(t *testing.T) {
+
+	os.Unsetenv("VSPHERETEST_MISSING")
+
+	chain := ChainCredentials{
+		EnvCredentials{UsernameVar: "VSPHERETEST_MISSING", PasswordVar: "VSPHERETEST_MISSING"},
+		StaticCredentials{Username: "first"},
+		FileCredentials{Path: filepath.Join(tempDir(t), "missing")},
+		&CredentialFile{Default: []Credentials{{Username: "second"}}},
+	}
+
+	creds, err := chain.Credentials(context.Background(), "web-01")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := usernames(creds); got != "first second" {
+		t.Errorf("got %s", got)
+	}
+
+	_, err = ChainCredentials{chain[0], chain[2]}.Credentials(context.Background(), "web-01")
+
+	if err == nil || !strings.Contains(err.Error(), "VSPHERETEST_MISSING") {
+		t.Errorf("all failing: got %v", err)
+	}
+}
+
+func TestEnvCredentialsPasswordUnset(t *testing.T) {
+
+	os.Setenv("VSPHERETEST_USER", "admin")
+	os.Unsetenv("VSPHERETEST_PASSWORD")
+	defer os.Unsetenv("VSPHERETEST_USER")
+
+	_, err := EnvCredentials{UsernameVar: "VSPHERETEST_USER", PasswordVar: "VSPHERETEST_PASSWORD"}.Credentials(context.Background(), "")
+
+	if err == nil || !strings.Contains(err.Error(), "VSPHERETEST_PASSWORD") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestUseCredentialsKeepsOtherAuthentication(t *testing.T) {
+
+	saml := &types.SAMLTokenAuthentication{Token: "token", Username: "admin"}
+
+	tests := []*ToolBoxClient{
+		{Client: toolbox.Client{Authentication: saml}},
+		{Client: toolbox.Client{Authentication: &types.NamePasswordAuthentication{Username: "admin"}}, acquired: true},
+	}
+
+	for _, c := range tests {
+		auth := c.Authentication
+
+		_, err := c.UseCredentials(context.Background(), StaticCredentials{Username: "other"}, "web-01")
+
+		if err == nil {
+			t.Errorf("%T: no error", auth)
+		}
+
+		if c.Authentication != auth {
+			t.Errorf("%T: authentication replaced by %T", auth, c.Authentication)
+		}
+	}
+}
+
+func usernames(creds []Credentials) string {
+	var names []string
+	for _, c := range creds {
+		names = append(names, c.Username)
+	}
+	return strings.Join(names, " ")
+}
+
+// noCredentials is a provider that has no accounts, without an error.
+type noCredentials struct{}
+
+func (noCredentials) Credentials(ctx context.Context, target string) ([]Credentials, error) {
+	return nil, nil
+}
+
+func TestNewClientWithCredentials(t *testing.T) {
+
+	model := simulator.VPX()
+	defer model.Remove()
+
+	if err := model.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := model.Service.NewServer()
+	defer s.Close()
+
+	u := *s.URL
+	u.User = nil
+
+	ctx := context.Background()
+
+	// the simulator rejects an empty password
+	provider := &CredentialFile{Default: []Credentials{{"admin", ""}, {"user", "pass"}}}
+
+	c, err := NewClientWithCredentials(ctx, u.String(), provider)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = c.Logout(ctx)
+
+	if _, err := NewClientWithCredentials(ctx, u.String(), noCredentials{}); err == nil || err.Error() != "no credentials for "+u.String() {
+		t.Errorf("no candidates: got %v", err)
+	}
+
+	// errors other than a rejected login are not tried with every account
+	_, err = NewClientWithCredentials(ctx, "http://127.0.0.1:1/sdk", provider)
+
+	if err == nil || strings.Contains(err.Error(), "accounts") {
+		t.Errorf("connection error: got %v", err)
+	}
+
+	_, err = NewClientWithCredentials(ctx, u.String(), &CredentialFile{Default: []Credentials{{"admin", ""}}})
+
+	if err == nil || !strings.Contains(err.Error(), "none of 1 accounts") || !isInvalidLogin(errors.Unwrap(err)) {
+		t.Errorf("rejected: got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Placeholders used in a Plan for guest temp files that a dry run does not create.
+const (
+	planStdout = "<stdout>"
+	planStderr = "<stderr>"
+	planStdin  = "<stdin>"
+	planScript = "<script.ps1>"
+	planUpload = "<upload>"
+)
+
+// PlannedStep is one guest change a dry run would have made, or with Kind "check"
+// a read-only guard command it would have run to decide on the other steps.
+type PlannedStep struct {
+	Kind        string `json:"kind"` // "command", "script", "upload", "remove", "registry", "alias" or "check"
+	VM          string `json:"vm"`   // VM name, or its managed object ID for registry and alias steps
+	ProgramPath string `json:"programPath,omitempty"`
+	Arguments   string `json:"arguments,omitempty"`
+	Script      string `json:"script,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Plan collects the steps of a dry run. Pass a *Plan as the "dryRun" option, or set it as
+// DryRun on a ToolBoxClient, RegistryManager or GuestAliasManager: the VM is still resolved
+// and tools and credentials are validated, but nothing is started, uploaded or changed in the guest.
+// Secrets registered with the DefaultRedactor are masked in the recorded steps.
+type Plan struct {
+	mu    sync.Mutex
+	Steps []PlannedStep
+}
+
+func (p *Plan) add(step PlannedStep) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	step.Arguments = DefaultRedactor.Redact(step.Arguments)
+	step.Script = DefaultRedactor.Redact(step.Script)
+	step.Description = DefaultRedactor.Redact(step.Description)
+
+	p.Steps = append(p.Steps, step)
+}
+
+func (p *Plan) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+
+	for i, s := range p.Steps {
+		fmt.Fprintf(&b, "%d. [vm] %s: %s", i+1, s.VM, s.Kind)
+
+		if s.ProgramPath != "" {
+			fmt.Fprintf(&b, " %s %s", s.ProgramPath, s.Arguments)
+		}
+		if s.Source != "" || s.Destination != "" {
+			fmt.Fprintf(&b, " %s -> %s", s.Source, s.Destination)
+		}
+		if s.Description != "" {
+			fmt.Fprintf(&b, " %s", s.Description)
+		}
+
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// planProgram records the program c would start for args instead of starting it.
+func (c ToolBoxClient) planProgram(kind string, args []string, script string) error {
+
+	spec, err := c.programSpec(args, planStdout, planStderr)
+
+	if err != nil {
+		return err
+	}
+
+	c.DryRun.add(PlannedStep{
+		Kind:        kind,
+		VM:          c.VMName,
+		ProgramPath: spec.ProgramPath,
+		Arguments:   spec.Arguments,
+		Script:      script,
+	})
+
+	return nil
+}
+
+// planUpload records the file move, or for directories the extraction commands, of UploadFile.
+func (c *ToolBoxClient) planUpload(ctx context.Context, dst string, isDir bool) error {
+
+	if !isDir {
+		c.DryRun.add(PlannedStep{Kind: "upload", VM: c.VMName, Source: planUpload, Destination: dst})
+		return nil
+	}
+
+	c.DryRun.add(PlannedStep{Kind: "upload", VM: c.VMName, Source: planUpload, Description: "archive of " + dst})
+
+	// planned directly, the hooks already saw the upload
+	for _, cmd := range extractCommands(planUpload, dst) {
+		if err := c.planProgram("command", syncCommandArgs(cmd), ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
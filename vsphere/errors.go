@@ -0,0 +1,150 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Sentinel errors matched with errors.Is against errors returned by the package.
+var (
+	ErrVMNotFound       = errors.New("virtual machine not found")
+	ErrVMAmbiguous      = errors.New("virtual machine name matches multiple virtual machines")
+	ErrToolsNotRunning  = errors.New("vmware tools not running")
+	ErrGuestAuth        = errors.New("guest authentication failed")
+	ErrGuestOpsNotReady = errors.New("guest operations not ready")
+	ErrGuestExit        = errors.New("guest program exited with non-zero status")
+	ErrTimeout          = errors.New("timed out")
+	ErrVetoed           = errors.New("guest operation vetoed by hook")
+	ErrUnsupported      = errors.New("not supported by this vCenter")
+
+	ErrCertificateMismatch = errors.New("certificate does not match pinned thumbprint")
+)
+
+// Error classifies a failure as one of the Err* sentinels while keeping the
+// underlying cause, typically a govmomi fault, reachable through errors.As.
+type Error struct {
+	Kind error
+	VM   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+
+	if e.VM != "" {
+		msg = fmt.Sprintf("[vm] %s: %s", e.VM, msg)
+	}
+
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+
+	return msg
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitError is returned when a guest program finishes with a non-zero exit code.
+type ExitError struct {
+	error
+	exitCode int
+}
+
+// NewExitError returns the error of program path exiting with exitCode.
+func NewExitError(path string, exitCode int) *ExitError {
+	return &ExitError{fmt.Errorf("%s: exit %d", path, exitCode), exitCode}
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.exitCode
+}
+
+func (e *ExitError) Is(target error) bool {
+	return target == ErrGuestExit
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.error
+}
+
+// vmLookupError classifies an error from find.Finder.VirtualMachine.
+func vmLookupError(vmName string, err error) error {
+
+	var notFound *find.NotFoundError
+	var multiple *find.MultipleFoundError
+
+	switch {
+	case errors.As(err, &notFound):
+		return &Error{Kind: ErrVMNotFound, VM: vmName, Err: err}
+	case errors.As(err, &multiple):
+		return &Error{Kind: ErrVMAmbiguous, VM: vmName, Err: err}
+	}
+
+	return err
+}
+
+// guestError classifies guest operation faults; errors it does not recognize are returned unchanged.
+func guestError(vmName string, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	var typed *Error
+	var exit *ExitError
+
+	if errors.As(err, &typed) || errors.As(err, &exit) {
+		return err
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Kind: ErrTimeout, VM: vmName, Err: err}
+	}
+
+	if kind := faultKind(err); kind != nil {
+		return &Error{Kind: kind, VM: vmName, Err: err}
+	}
+
+	return err
+}
+
+func faultKind(err error) error {
+
+	switch vimFault(err).(type) {
+	case types.InvalidGuestLogin, *types.InvalidGuestLogin,
+		types.GuestPermissionDenied, *types.GuestPermissionDenied,
+		types.GuestAuthenticationChallenge, *types.GuestAuthenticationChallenge:
+		return ErrGuestAuth
+	case types.GuestOperationsUnavailable, *types.GuestOperationsUnavailable:
+		return ErrGuestOpsNotReady
+	case types.ToolsUnavailable, *types.ToolsUnavailable:
+		return ErrToolsNotRunning
+	case types.MethodNotFound, *types.MethodNotFound,
+		types.NotSupported, *types.NotSupported:
+		return ErrUnsupported
+	}
+
+	return nil
+}
+
+// vimFault returns the fault carried by a govmomi SOAP or vim fault error, or nil.
+func vimFault(err error) interface{} {
+	switch {
+	case soap.IsSoapFault(err):
+		return soap.ToSoapFault(err).VimFault()
+	case soap.IsVimFault(err):
+		return soap.ToVimFault(err)
+	}
+	return nil
+}
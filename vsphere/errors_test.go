@@ -0,0 +1,46 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestManagerFaults(t *testing.T) {
+
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+
+		// the session manager has none of the guest methods, so vCenter answers MethodNotFound
+		ref := *c.ServiceContent.SessionManager
+
+		r := &RegistryManager{c: c, ref: ref, vmName: "vm"}
+
+		err := r.CreateKey(ctx, `HKLM\SOFTWARE\app`, false)
+
+		var e *Error
+
+		if !errors.Is(err, ErrUnsupported) || !errors.As(err, &e) || e.VM != "vm" {
+			t.Errorf("registry: got %v, want ErrUnsupported for the VM", err)
+		}
+
+		if _, err := r.ListValues(ctx, `HKLM\SOFTWARE\app`, false, ""); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("registry: got %v, want ErrUnsupported", err)
+		}
+
+		a := &GuestAliasManager{c: c, ref: ref, vmName: "vm"}
+
+		_, err = a.List(ctx, "user")
+
+		if _, ok := vimFault(errors.Unwrap(err)).(types.MethodNotFound); !errors.Is(err, ErrUnsupported) || !ok {
+			t.Errorf("alias: got %v, want ErrUnsupported wrapping the fault", err)
+		}
+
+		if !errors.As(err, &e) || e.VM != "vm" {
+			t.Errorf("alias: got %v, want the error to name the VM", err)
+		}
+	})
+}
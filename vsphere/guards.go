@@ -0,0 +1,181 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Command is a guest command with Ansible-style guards; all set guards must pass for Cmd to run.
+type Command struct {
+	Cmd     string
+	Creates string // skip when this guest path exists
+	Removes string // run only when this guest path exists
+	OnlyIf  string // run only when this guard command exits 0
+	Unless  string // skip when this guard command exits 0
+}
+
+type CommandResult struct {
+	Command string
+	Skipped bool
+	Reason  string // why the command was skipped
+	Output  *CmdOutput
+}
+
+// PathExists reports whether a file or directory exists in the guest.
+func (c *ToolBoxClient) PathExists(ctx context.Context, path string) (bool, error) {
+
+	_, err := c.FileManager.ListFiles(ctx, c.Authentication, path, 0, 1, "")
+
+	if err == nil {
+		return true, nil
+	}
+
+	if isFileNotFound(err) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// ShouldRun evaluates the guards of cmd and returns false with the reason when it must be skipped.
+func (c *ToolBoxClient) ShouldRun(ctx context.Context, cmd Command) (bool, string, error) {
+
+	if cmd.Creates != "" {
+		exists, err := c.PathExists(ctx, cmd.Creates)
+		if err != nil {
+			return false, "", err
+		}
+		if exists {
+			return false, fmt.Sprintf("%s exists", cmd.Creates), nil
+		}
+	}
+
+	if cmd.Removes != "" {
+		exists, err := c.PathExists(ctx, cmd.Removes)
+		if err != nil {
+			return false, "", err
+		}
+		if !exists {
+			return false, fmt.Sprintf("%s does not exist", cmd.Removes), nil
+		}
+	}
+
+	if cmd.OnlyIf != "" {
+		ok, err := c.guardSucceeds(ctx, cmd.OnlyIf)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, "onlyIf guard failed", nil
+		}
+	}
+
+	if cmd.Unless != "" {
+		ok, err := c.guardSucceeds(ctx, cmd.Unless)
+		if err != nil {
+			return false, "", err
+		}
+		// a dry run only plans the guard, so it cannot tell whether it would succeed
+		if ok && c.DryRun == nil {
+			return false, "unless guard succeeded", nil
+		}
+	}
+
+	return true, "", nil
+}
+
+func (c *ToolBoxClient) guardSucceeds(ctx context.Context, guard string) (bool, error) {
+
+	// a guard only reads, so a dry run plans it as a check and assumes it succeeds
+	if c.DryRun != nil {
+		return true, c.planProgram("check", syncCommandArgs(guard), "")
+	}
+
+	_, err := c.RunCmdSync(ctx, guard)
+
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, ErrGuestExit) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// InvokeGuardedCommands runs commands like InvokeCommandsSync, skipping those whose
+// guards do not pass. Every command, run or skipped, gets a result.
+func InvokeGuardedCommands(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, commands []Command, options map[string]interface{}) (results []CommandResult, err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(vmName, err))
+	}()
+
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tboxClient.ReleaseCredentials(ctx)
+
+	o, _ := options["output"].(terraform.UIOutput)
+	o = DefaultRedactor.Output(o)
+
+	for _, command := range commands {
+
+		if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+			return results, err
+		}
+
+		run, reason, err := tboxClient.ShouldRun(ctx, command)
+
+		if err != nil {
+			return results, err
+		}
+
+		if !run {
+			logInfo("skipping command", "vm", vmName, "command", command.Cmd, "reason", reason)
+			results = append(results, CommandResult{Command: command.Cmd, Skipped: true, Reason: reason})
+			continue
+		}
+
+		logInfo("running command", "vm", vmName, "command", command.Cmd)
+
+		cmdOutput, err := tboxClient.RunCmdSync(ctx, command.Cmd)
+
+		results = append(results, CommandResult{Command: command.Cmd, Output: cmdOutput})
+
+		if err != nil {
+			return results, err
+		}
+
+		if o != nil && cmdOutput != nil {
+			if strings.TrimSpace(cmdOutput.Stdout) != "" {
+				o.Output(cmdOutput.Stdout)
+			}
+
+			if strings.TrimSpace(cmdOutput.Stderr) != "" {
+				o.Output(cmdOutput.Stderr)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func isFileNotFound(err error) bool {
+	switch vimFault(err).(type) {
+	case types.FileNotFound, *types.FileNotFound:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package vsphere_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+func TestInvokeGuardedCommands(t *testing.T) {
+
+	g := &vspheretest.Guest{
+		Handler: func(p *vspheretest.Process) {
+			if strings.Contains(p.Command(), "Test-Installed") {
+				p.ExitCode = 1
+			}
+		},
+	}
+	g.WriteFile(`C:\app\installed.txt`, []byte("yes"))
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	results, err := vsphere.InvokeGuardedCommands(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret", []vsphere.Command{
+		{Cmd: "Install-App", Creates: `C:\app\installed.txt`},
+		{Cmd: "Configure-App", OnlyIf: "Test-Installed"},
+		{Cmd: "Start-App", Unless: "Test-Installed"},
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Command+":"+r.Reason)
+	}
+
+	want := `Install-App:C:\app\installed.txt exists|Configure-App:onlyIf guard failed|Start-App:`
+
+	if strings.Join(got, "|") != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestInvokeGuardedCommandsDryRun(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	plan := new(vsphere.Plan)
+
+	_, err := vsphere.InvokeGuardedCommands(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret", []vsphere.Command{
+		{Cmd: "Configure-App", OnlyIf: "Test-Installed"},
+		{Cmd: "Start-App", Unless: "Test-Running"},
+	}, map[string]interface{}{"dryRun": plan})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(g.Processes()); n != 0 {
+		t.Errorf("dry run started %d programs", n)
+	}
+
+	var kinds []string
+	for _, step := range plan.Steps {
+		kinds = append(kinds, step.Kind)
+	}
+
+	// guards are read-only checks, not changes
+	if got := strings.Join(kinds, " "); got != "check command check command" {
+		t.Errorf("planned %s:\n%s", got, plan)
+	}
+}
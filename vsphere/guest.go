@@ -2,14 +2,21 @@ package vsphere
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/sethvargo/go-retry"
-	"github.com/vmware/govmomi"
-	"github.com/vmware/govmomi/find"
 	"io"
+	"reflect"
 	"strings"
 	"time"
 
+	"github.com/sethvargo/go-retry"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+
 	"github.com/hashicorp/terraform/terraform"
 	//"github.com/roshankarande/go-vsphere/vsphere/guest/toolbox"
 	"github.com/vmware/govmomi/guest"
@@ -17,39 +24,25 @@ import (
 )
 
 const (
-	DefaultDelay  = time.Duration(20)
+	DefaultDelay   = time.Duration(20)
 	DefaultTimeout = time.Duration(400)
 )
 
-func InvokeCommands(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, commands []string,  options  map[string]interface{}) error{
+func InvokeCommands(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, commands []string, options map[string]interface{}) (err error) {
 
-	vm, err := find.NewFinder(c.Client).VirtualMachine(ctx, vmName)
+	defer func() {
+		err = DefaultRedactor.Error(guestError(vmName, err))
+	}()
 
-	if err != nil {
-		return fmt.Errorf("[vm] %s does not exist in [vc]", vmName)
-	}
-
-	opsmgr := guest.NewOperationsManager(c.Client, vm.Reference())
-
-	tboxClient,err  := NewToolBoxClient(ctx,opsmgr,guestUser,guestPassword,types.VirtualMachineGuestOsFamilyWindowsGuest)
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
 
 	if err != nil {
 		return err
 	}
 
-	delay, ok := options["delay"].(time.Duration)
-
-	if !ok {
-		delay = DefaultDelay
-	}
-
-	timeout, ok := options["timeout"].(time.Duration)
+	defer tboxClient.ReleaseCredentials(ctx)
 
-	if !ok {
-		timeout = DefaultTimeout
-	}
-
-	b, err := retry.NewConstant(delay*time.Second)
+	options, err = bufferStdin(options)
 	if err != nil {
 		return err
 	}
@@ -57,31 +50,8 @@ func InvokeCommands(ctx context.Context, c *govmomi.Client, vmName, guestUser, g
 	for _, command := range commands {
 		//fmt.Printf("[cmd]%s\n", command)
 
-		err = retry.Do(ctx, retry.WithMaxDuration( timeout * time.Second, b), func(ctx context.Context) error {
-			running, err := vm.IsToolsRunning(ctx)
-
-			if err != nil {
-				return err
-			}
-
-			if !running{
-				//fmt.Println("tools not running")
-				return retry.RetryableError(fmt.Errorf("tools not running"))
-			}
-
-			//if running{
-			//	fmt.Println("tools are running")
-			//}
-
-			return nil
-		})
-
-		if err != nil {
-			return fmt.Errorf("error with querying vmware tools status")
-		}
-
-		if err := tboxClient.TestCredentials(ctx); err != nil {
-			return fmt.Errorf("authentication details not correct %s",err)
+		if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+			return err
 		}
 
 		if err := tboxClient.RunCmd(ctx, command, options); err != nil {
@@ -92,79 +62,50 @@ func InvokeCommands(ctx context.Context, c *govmomi.Client, vmName, guestUser, g
 	return nil
 }
 
-func InvokeCommandsSync(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, commands []string, options map[string]interface{}) error {
-
-	vm, err := find.NewFinder(c.Client).VirtualMachine(ctx, vmName)
+func InvokeCommandsSync(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, commands []string, options map[string]interface{}) (err error) {
 
-	if err != nil {
-		return err
-	}
+	defer func() {
+		err = DefaultRedactor.Error(guestError(vmName, err))
+	}()
 
-	opsmgr := guest.NewOperationsManager(c.Client, vm.Reference())
-
-
-	tboxClient,err  := NewToolBoxClient(ctx,opsmgr,guestUser,guestPassword,types.VirtualMachineGuestOsFamilyWindowsGuest)
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
 
 	if err != nil {
 		return err
 	}
 
-	delay, ok := options["delay"].(time.Duration)
-
-	if !ok {
-		delay = DefaultDelay
-	}
-
-	timeout, ok := options["timeout"].(time.Duration)
-
-	if !ok {
-		timeout = DefaultTimeout
-	}
+	defer tboxClient.ReleaseCredentials(ctx)
 
 	_, oSpecPresent := options["output"]
 
 	var o terraform.UIOutput
 
-	if oSpecPresent{
+	if oSpecPresent {
+		var ok bool
 		o, ok = options["output"].(terraform.UIOutput)
 
-		if !ok{
+		if !ok {
 			return fmt.Errorf("not able to assert terraform.UIOutput")
 		}
+
+		o = DefaultRedactor.Output(o)
 	}
 
-	b, err := retry.NewConstant(delay*time.Second)
+	options, err = bufferStdin(options)
 	if err != nil {
 		return err
 	}
 
-	for _, command := range commands {
-		fmt.Printf("[cmd]%s\n", command)
-
-		err = retry.Do(ctx, retry.WithMaxDuration( timeout *time.Second, b), func(ctx context.Context) error {
-			running, err := vm.IsToolsRunning(ctx)
-
-			if err != nil {
-				return err
-			}
-
-			if !running{
-				//fmt.Println("tools not running")
-				return retry.RetryableError(fmt.Errorf("tools not running"))
-			}
-
-			return nil
-		})
+	stdin, _ := StdinReader(options["stdin"])
 
-		if err != nil {
-			return fmt.Errorf("error with querying vmware tools status")
-		}
+	for _, command := range commands {
+		logInfo("running command", "vm", vmName, "command", command)
 
-		if err := tboxClient.TestCredentials(ctx); err != nil {
-			return fmt.Errorf("authentication details not correct %s",err)
+		if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+			return err
 		}
 
-		cmdOutput, err := tboxClient.RunCmdSync(ctx,command)
+		cmdOutput, err := tboxClient.RunCmdSyncInput(ctx, command, stdin)
 
 		if err != nil {
 			return err
@@ -189,88 +130,152 @@ func InvokeCommandsSync(ctx context.Context, c *govmomi.Client, vmName, guestUse
 	return nil
 }
 
-func InvokeScript(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, script string,  options  map[string]interface{}) error{
+func InvokeScript(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, script string, options map[string]interface{}) (err error) {
 
-	vm, err := find.NewFinder(c.Client).VirtualMachine(ctx, vmName)
+	defer func() {
+		err = DefaultRedactor.Error(guestError(vmName, err))
+	}()
+
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
 
 	if err != nil {
-		return fmt.Errorf("[vm] %s does not exist in [vc]", vmName)
+		return err
 	}
 
-	opsmgr := guest.NewOperationsManager(c.Client, vm.Reference())
+	defer tboxClient.ReleaseCredentials(ctx)
 
-	tboxClient,err  := NewToolBoxClient(ctx,opsmgr,guestUser,guestPassword,types.VirtualMachineGuestOsFamilyWindowsGuest)
+	logInfo("executing script", "vm", vmName)
 
-	if err != nil {
+	if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
 		return err
 	}
 
-	delay, ok := options["delay"].(time.Duration)
+	return tboxClient.RunScript(ctx, script, options)
 
-	if !ok {
-		delay = DefaultDelay
+}
+
+func Upload(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, f io.Reader, suffix, dst string, isDir bool, options map[string]interface{}) (err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(vmName, err))
+	}()
+
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return err
 	}
 
-	timeout, ok := options["timeout"].(time.Duration)
+	defer tboxClient.ReleaseCredentials(ctx)
 
-	if !ok {
-		timeout = DefaultTimeout
+	//fmt.Println("[uploading]")
+
+	if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+		return err
 	}
 
-	b, err := retry.NewConstant(delay*time.Second)
+	return tboxClient.UploadFile(ctx, dst, f, suffix, isDir)
+}
+
+func TestCredentials(ctx context.Context, baseGuestAuth types.BaseGuestAuthentication, opsmgr *guest.OperationsManager) error {
+
+	authmgr, err := opsmgr.AuthManager(ctx)
+
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[executing script]")
+	err = authmgr.ValidateCredentials(ctx, baseGuestAuth)
 
-	err = retry.Do(ctx, retry.WithMaxDuration( timeout * time.Second, b), func(ctx context.Context) error {
-			running, err := vm.IsToolsRunning(ctx)
+	if err != nil {
+		return err
+	}
 
-			if err != nil {
-				return err
-			}
+	return nil
+}
 
-			if !running{
-				//fmt.Println("tools not running")
-				return retry.RetryableError(fmt.Errorf("tools not running"))
-			}
+// CheckInteractiveSession returns an error unless a user is logged on to the
+// guest desktop, which is required to run programs in the interactive session.
+func CheckInteractiveSession(ctx context.Context, vm *object.VirtualMachine) error {
 
-			//if running{
-			//	fmt.Println("tools are running")
-			//}
+	var mvm mo.VirtualMachine
 
-			return nil
-		})
+	err := vm.Properties(ctx, vm.Reference(), []string{"guest.interactiveGuestOperationsReady"}, &mvm)
 
 	if err != nil {
-		return fmt.Errorf("error with querying vmware tools status")
+		return err
 	}
 
-	if err := tboxClient.TestCredentials(ctx); err != nil {
-		return fmt.Errorf("authentication details not correct %s",err)
+	if mvm.Guest == nil || mvm.Guest.InteractiveGuestOperationsReady == nil || !*mvm.Guest.InteractiveGuestOperationsReady {
+		return &Error{Kind: ErrGuestOpsNotReady, VM: vm.Name(), Err: errors.New("no user is logged on to an interactive session")}
 	}
 
-	return tboxClient.RunScript(ctx,script,options)
+	return nil
+}
+
+// guestOperationsManager retrieves a single property of the GuestOperationsManager.
+func guestOperationsManager(ctx context.Context, c *vim25.Client, prop string) (*mo.GuestOperationsManager, error) {
+
+	var g mo.GuestOperationsManager
+
+	err := property.DefaultCollector(c).RetrieveOne(ctx, *c.ServiceContent.GuestOperationsManager, []string{prop}, &g)
+
+	if err != nil {
+		return nil, err
+	}
 
+	return &g, nil
 }
 
-func Upload(ctx context.Context,c *govmomi.Client, vmName, guestUser, guestPassword string, f io.Reader,suffix, dst string, isDir bool, options map[string]interface{}) error {
+// newGuestSession resolves vmName and builds a ToolBoxClient for it from options.
+func newGuestSession(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, options map[string]interface{}) (*object.VirtualMachine, *ToolBoxClient, error) {
 
 	vm, err := find.NewFinder(c.Client).VirtualMachine(ctx, vmName)
 
 	if err != nil {
-		return fmt.Errorf("[vm] %s does not exist in [vc]", vmName)
+		return nil, nil, vmLookupError(vmName, err)
 	}
 
 	opsmgr := guest.NewOperationsManager(c.Client, vm.Reference())
 
-	tboxClient,err  := NewToolBoxClient(ctx,opsmgr,guestUser,guestPassword,types.VirtualMachineGuestOsFamilyWindowsGuest)
+	tboxClient, err := NewToolBoxClient(ctx, opsmgr, guestUser, guestPassword, types.VirtualMachineGuestOsFamilyWindowsGuest, toolBoxOptions(options)...)
 
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	tboxClient.VM = vm.Reference()
+	tboxClient.VMName = vm.Name()
+	tboxClient.VCenter = c.URL().Host
+
+	if a, ok := options["audit"].(*Auditor); ok {
+		tboxClient.Auditor = a
+	}
+
+	if hooks, ok := options["hooks"].([]GuestHook); ok {
+		tboxClient.Use(hooks...)
+	}
+
+	if prefix, ok := options["tempPrefix"].(string); ok {
+		tboxClient.TempPrefix = prefix
+	}
+
+	if dir, ok := options["tempDir"].(string); ok {
+		tboxClient.TempDir = dir
 	}
 
+	if plan, ok := options["dryRun"].(*Plan); ok {
+		tboxClient.DryRun = plan
+	}
+
+	return vm, tboxClient, nil
+}
+
+// waitForGuest polls until VMware Tools is running and, when the interactive option is set,
+// checks that a user is logged on. It then validates the guest credentials, falling back to
+// the accounts of the "credentials" provider option when they are rejected.
+func waitForGuest(ctx context.Context, vm *object.VirtualMachine, tboxClient *ToolBoxClient, options map[string]interface{}) error {
+
 	delay, ok := options["delay"].(time.Duration)
 
 	if !ok {
@@ -283,56 +288,104 @@ func Upload(ctx context.Context,c *govmomi.Client, vmName, guestUser, guestPassw
 		timeout = DefaultTimeout
 	}
 
-	b, err := retry.NewConstant(delay*time.Second)
+	b, err := retry.NewConstant(delay * time.Second)
 	if err != nil {
 		return err
 	}
 
-	//fmt.Println("[uploading]")
-
-	err = retry.Do(ctx, retry.WithMaxDuration( timeout * time.Second, b), func(ctx context.Context) error {
+	err = retry.Do(ctx, retry.WithMaxDuration(timeout*time.Second, b), func(ctx context.Context) error {
 		running, err := vm.IsToolsRunning(ctx)
 
 		if err != nil {
 			return err
 		}
 
-		if !running{
+		if !running {
 			//fmt.Println("tools not running")
-			return retry.RetryableError(fmt.Errorf("tools not running"))
+			return retry.RetryableError(ErrToolsNotRunning)
 		}
 
-		//if running{
-		//	fmt.Println("tools are running")
-		//}
-
 		return nil
 	})
 
-	if err != nil {
-		return fmt.Errorf("error with querying vmware tools status")
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrToolsNotRunning):
+		return &Error{Kind: ErrTimeout, VM: vm.Name(), Err: fmt.Errorf("waited %s: %w", timeout*time.Second, ErrToolsNotRunning)}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Kind: ErrTimeout, VM: vm.Name(), Err: err}
+	default:
+		return &Error{Kind: ErrToolsNotRunning, VM: vm.Name(), Err: err}
+	}
+
+	// fail fast, before spending login attempts on a guest nobody is logged on to
+	if interactive, _ := options["interactive"].(bool); interactive {
+		if err := CheckInteractiveSession(ctx, vm); err != nil {
+			return err
+		}
 	}
 
 	if err := tboxClient.TestCredentials(ctx); err != nil {
-		return fmt.Errorf("authentication details not correct %s",err)
+		provider, ok := options["credentials"].(CredentialProvider)
+
+		if !ok {
+			return authError(vm.Name(), err)
+		}
+
+		if _, err := tboxClient.UseCredentials(ctx, provider, vm.Name()); err != nil {
+			return authError(vm.Name(), err)
+		}
 	}
 
-	return tboxClient.UploadFile(ctx,dst, f,suffix, isDir)
+	return nil
 }
 
-func TestCredentials(ctx context.Context, baseGuestAuth types.BaseGuestAuthentication, opsmgr *guest.OperationsManager) error {
+// toolBoxOptions translates the guest options map into ToolBoxClient options.
+func toolBoxOptions(options map[string]interface{}) []ToolBoxOption {
 
-	authmgr, err := opsmgr.AuthManager(ctx)
+	var opts []ToolBoxOption
 
-	if err != nil {
-		return err
+	if interactive, _ := options["interactive"].(bool); interactive {
+		opts = append(opts, WithInteractiveSession())
 	}
 
-	err = authmgr.ValidateCredentials(ctx, baseGuestAuth)
+	if token, ok := options["samlToken"].(string); ok {
+		opts = append(opts, WithSAMLToken(token))
+	}
 
-	if err != nil {
-		return err
+	if ticket, ok := options["ticket"].(string); ok {
+		opts = append(opts, WithTicketedSession(ticket))
 	}
 
-	return nil
+	if acquire, _ := options["acquireCredentials"].(bool); acquire {
+		opts = append(opts, WithAcquiredCredentials(intOption(options["sessionID"])))
+	}
+
+	return opts
+}
+
+// intOption returns an integer option of any type as int64, so that a sessionID given
+// as an int is not ignored; other values are 0.
+func intOption(v interface{}) int64 {
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	}
+
+	return 0
+}
+
+// authError reports a rejected credential check, unless the fault shows the guest was not ready for it.
+func authError(vmName string, err error) error {
+
+	if kind := faultKind(err); kind != nil && kind != ErrGuestAuth {
+		return &Error{Kind: kind, VM: vmName, Err: err}
+	}
+
+	return &Error{Kind: ErrGuestAuth, VM: vmName, Err: err}
 }
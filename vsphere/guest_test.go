@@ -0,0 +1,327 @@
+package vsphere_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi/guest"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type output []string
+
+func (o *output) Output(s string) {
+	*o = append(*o, s)
+}
+
+func TestInvokeCommandsSync(t *testing.T) {
+
+	g := &vspheretest.Guest{
+		Username: "admin",
+		Password: "secret",
+		Handler: func(p *vspheretest.Process) {
+			p.Stdout = "ran " + p.Command()
+		},
+	}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	var o output
+
+	err := vsphere.InvokeCommandsSync(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		[]string{"Get-Date", "hostname"}, map[string]interface{}{"output": &o})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"ran Get-Date", "ran hostname"}; strings.Join(o, "|") != strings.Join(want, "|") {
+		t.Errorf("got output %q, want %q", o, want)
+	}
+
+	if n := len(g.Processes()); n != 2 {
+		t.Errorf("started %d programs, want 2", n)
+	}
+
+	// output files are removed after the command
+	for _, path := range g.Files() {
+		t.Errorf("left %s in the guest", path)
+	}
+}
+
+func TestInvokeCommandsSyncStdin(t *testing.T) {
+
+	g := &vspheretest.Guest{
+		Handler: func(p *vspheretest.Process) {
+			p.Stdout = string(p.Stdin)
+		},
+	}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	var o output
+
+	err := vsphere.InvokeCommandsSync(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		[]string{"Write-Output $input"}, map[string]interface{}{"output": &o, "stdin": strings.NewReader("hello")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(o) != 1 || o[0] != "hello" {
+		t.Errorf("got output %q, want [hello]", o)
+	}
+}
+
+func TestInvokeCommandsSyncExitCode(t *testing.T) {
+
+	g := &vspheretest.Guest{
+		Handler: func(p *vspheretest.Process) {
+			p.Stderr = "boom"
+			p.ExitCode = 3
+		},
+	}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	err := vsphere.InvokeCommandsSync(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		[]string{"exit 3"}, nil)
+
+	var exit *vsphere.ExitError
+
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Fatalf("got %v, want exit code 3", err)
+	}
+}
+
+func TestInvokeCommandsBadCredentials(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	err := vsphere.InvokeCommandsSync(context.Background(), s.Client, "DC0_H0_VM0", "admin", "wrong",
+		[]string{"Get-Date"}, nil)
+
+	if !errors.Is(err, vsphere.ErrGuestAuth) {
+		t.Errorf("got %v, want ErrGuestAuth", err)
+	}
+
+	if n := len(g.Processes()); n != 0 {
+		t.Errorf("started %d programs", n)
+	}
+}
+
+func TestUpload(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	err := vsphere.Upload(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		bytes.NewReader([]byte("content")), ".txt", `C:\app\config.txt`, false, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := g.ReadFile(`C:\app\config.txt`)
+
+	if !ok || string(data) != "content" {
+		t.Errorf("got %q, want the uploaded content", data)
+	}
+}
+
+func TestInvokeCommandsNilStdin(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	for _, stdin := range []interface{}{nil, (*bytes.Reader)(nil)} {
+		var o output
+
+		err := vsphere.InvokeCommands(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+			[]string{"Get-Date"}, map[string]interface{}{"output": &o, "stdin": stdin})
+
+		if err != nil {
+			t.Errorf("stdin %#v: %v", stdin, err)
+		}
+	}
+
+	for _, p := range g.Processes() {
+		if p.Stdin != nil || strings.Contains(p.Arguments, "Get-Content") {
+			t.Errorf("stdin piped to %q", p.Arguments)
+		}
+	}
+}
+
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) Credentials(ctx context.Context, target string) ([]vsphere.Credentials, error) {
+	p.calls++
+	return []vsphere.Credentials{{Username: "admin", Password: "secret"}}, nil
+}
+
+func TestInteractiveSessionCheckedFirst(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	s.VirtualMachine("DC0_H0_VM0").Guest.InteractiveGuestOperationsReady = types.NewBool(false)
+
+	provider := new(countingProvider)
+
+	err := vsphere.InvokeCommandsSync(context.Background(), s.Client, "DC0_H0_VM0", "admin", "wrong",
+		[]string{"Get-Date"}, map[string]interface{}{"interactive": true, "credentials": provider})
+
+	if !errors.Is(err, vsphere.ErrGuestOpsNotReady) {
+		t.Errorf("got %v, want ErrGuestOpsNotReady", err)
+	}
+
+	if provider.calls != 0 {
+		t.Errorf("credential provider asked %d times before the interactive check", provider.calls)
+	}
+}
+
+func TestUseCredentialsFallback(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	c := toolBoxClient(t, s, "DC0_H0_VM0")
+
+	provider := &vsphere.CredentialFile{Default: []vsphere.Credentials{{Username: "admin", Password: "wrong"}, {Username: "admin", Password: "secret"}}}
+
+	cred, err := c.UseCredentials(context.Background(), provider, "DC0_H0_VM0")
+
+	if err != nil || cred.Password != "secret" {
+		t.Fatalf("got %+v, %v", cred, err)
+	}
+
+	// only a rejected account falls back to the next one
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.UseCredentials(ctx, provider, "DC0_H0_VM0"); !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "accounts") {
+		t.Errorf("got %v, want the context error", err)
+	}
+}
+
+func TestInvokeCommandsTicketedSession(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	ctx := context.Background()
+
+	vm := s.VirtualMachine("DC0_H0_VM0")
+	auth, err := guest.NewOperationsManager(s.Client.Client, vm.Reference()).AuthManager(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired, err := auth.AcquireCredentials(ctx, &types.NamePasswordAuthentication{Username: "admin", Password: "secret"}, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket := acquired.(*types.TicketedSessionAuthentication).Ticket
+
+	err = vsphere.InvokeCommandsSync(ctx, s.Client, "DC0_H0_VM0", "", "",
+		[]string{"hostname"}, map[string]interface{}{"ticket": ticket})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := g.Processes()
+
+	if len(p) != 1 {
+		t.Fatalf("started %d programs, want 1", len(p))
+	}
+
+	if a, ok := p[0].Auth.(*types.TicketedSessionAuthentication); !ok || a.Ticket != ticket {
+		t.Errorf("started with %#v, want the ticket", p[0].Auth)
+	}
+
+	// a ticket passed in belongs to the caller and is not released
+	if n := len(g.Tickets()); n != 1 {
+		t.Errorf("%d tickets left, want 1", n)
+	}
+}
+
+func TestInvokeCommandsAcquiredCredentials(t *testing.T) {
+
+	var held map[string]int64
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+	g.Handler = func(p *vspheretest.Process) {
+		held = g.Tickets()
+	}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	var o output
+
+	err := vsphere.InvokeCommands(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		[]string{"hostname"}, map[string]interface{}{"output": &o, "acquireCredentials": true, "sessionID": 7})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := g.Processes()
+
+	if len(p) != 1 {
+		t.Fatalf("started %d programs, want 1", len(p))
+	}
+
+	a, ok := p[0].Auth.(*types.TicketedSessionAuthentication)
+
+	if !ok {
+		t.Fatalf("started with %#v, want a ticket", p[0].Auth)
+	}
+
+	if id, ok := held[a.Ticket]; !ok || id != 7 {
+		t.Errorf("got tickets %v while running, want %s for session 7", held, a.Ticket)
+	}
+
+	if tickets := g.Tickets(); len(tickets) != 0 {
+		t.Errorf("tickets %v not released", tickets)
+	}
+}
+
+func TestInvokeCommandsSAMLToken(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	err := vsphere.InvokeCommandsSync(context.Background(), s.Client, "DC0_H0_VM0", "admin", "",
+		[]string{"hostname"}, map[string]interface{}{"samlToken": "<saml2:Assertion/>"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := g.Processes()
+
+	if len(p) != 1 {
+		t.Fatalf("started %d programs, want 1", len(p))
+	}
+
+	if a, ok := p[0].Auth.(*types.SAMLTokenAuthentication); !ok || a.Token != "<saml2:Assertion/>" || a.Username != "admin" {
+		t.Errorf("started with %#v, want the SAML token for admin", p[0].Auth)
+	}
+}
@@ -0,0 +1,90 @@
+package vsphere
+
+import (
+	"context"
+	"time"
+)
+
+// GuestOperation is passed to hooks before a guest command, script or upload runs.
+// Before hooks may rewrite Command, which holds the command line or script body, and
+// the Destination of an upload.
+type GuestOperation struct {
+	Kind        string // "command", "script" or "upload"
+	VM          string
+	Command     string
+	Destination string
+}
+
+type GuestResult struct {
+	Output   *CmdOutput // only set for RunCmdSync
+	Err      error
+	Duration time.Duration
+}
+
+// GuestHook wraps every guest operation of a ToolBoxClient. Before hooks run in
+// the order they were added and can veto the operation by returning an error;
+// After hooks run in reverse order once it finished. Helper commands the package
+// runs as part of an operation, like unpacking an uploaded directory, are not
+// passed to hooks separately.
+type GuestHook interface {
+	Before(ctx context.Context, op *GuestOperation) error
+	After(ctx context.Context, op *GuestOperation, res *GuestResult)
+}
+
+// GuestHookFuncs adapts plain functions to GuestHook; either may be nil.
+type GuestHookFuncs struct {
+	BeforeFunc func(ctx context.Context, op *GuestOperation) error
+	AfterFunc  func(ctx context.Context, op *GuestOperation, res *GuestResult)
+}
+
+func (h GuestHookFuncs) Before(ctx context.Context, op *GuestOperation) error {
+	if h.BeforeFunc == nil {
+		return nil
+	}
+	return h.BeforeFunc(ctx, op)
+}
+
+func (h GuestHookFuncs) After(ctx context.Context, op *GuestOperation, res *GuestResult) {
+	if h.AfterFunc != nil {
+		h.AfterFunc(ctx, op, res)
+	}
+}
+
+// Use appends hooks to the chain and returns c so calls can be chained.
+func (c *ToolBoxClient) Use(hooks ...GuestHook) *ToolBoxClient {
+	c.Hooks = append(c.Hooks, hooks...)
+	return c
+}
+
+// runHooks runs the Before hooks for op and returns the function that runs the
+// After hooks of those that were called. A veto is reported as ErrVetoed.
+func (c *ToolBoxClient) runHooks(ctx context.Context, op *GuestOperation) (func(*CmdOutput, error), error) {
+
+	start := time.Now()
+
+	var called []GuestHook
+
+	after := func(out *CmdOutput, err error) {
+		res := &GuestResult{
+			Output:   out,
+			Err:      DefaultRedactor.Error(err),
+			Duration: time.Since(start),
+		}
+
+		for i := len(called) - 1; i >= 0; i-- {
+			called[i].After(ctx, op, res)
+		}
+	}
+
+	for _, h := range c.Hooks {
+		called = append(called, h)
+
+		if err := h.Before(ctx, op); err != nil {
+			err = &Error{Kind: ErrVetoed, VM: c.VMName, Err: err}
+			after(nil, err)
+			return nil, err
+		}
+	}
+
+	return after, nil
+}
@@ -0,0 +1,203 @@
+package vsphere_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+func TestHooksUploadDirectory(t *testing.T) {
+
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dryRun=%t", dryRun), func(t *testing.T) {
+			testHooksUploadDirectory(t, dryRun)
+		})
+	}
+}
+
+func testHooksUploadDirectory(t *testing.T, dryRun bool) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	var ops []string
+
+	// a policy forbidding commands must not break uploads half way
+	hook := vsphere.GuestHookFuncs{
+		BeforeFunc: func(ctx context.Context, op *vsphere.GuestOperation) error {
+			ops = append(ops, op.Kind)
+			if op.Kind == "command" {
+				return errors.New("commands are not allowed")
+			}
+			return nil
+		},
+	}
+
+	options := map[string]interface{}{"hooks": []vsphere.GuestHook{hook}}
+
+	plan := new(vsphere.Plan)
+
+	if dryRun {
+		options["dryRun"] = plan
+	}
+
+	err := vsphere.Upload(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		bytes.NewReader([]byte("archive")), ".tar.gz", `C:\app`, true, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 1 || ops[0] != "upload" {
+		t.Errorf("hooks saw %q, want one upload", ops)
+	}
+
+	if dryRun && len(plan.Steps) != 3 {
+		t.Errorf("planned %d steps, want the upload and 2 extraction commands:\n%s", len(plan.Steps), plan)
+	}
+}
+
+func TestHooksVeto(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	c := toolBoxClient(t, s, "DC0_H0_VM0")
+
+	var res *vsphere.GuestResult
+	next := false
+
+	c.Use(vsphere.GuestHookFuncs{
+		BeforeFunc: func(ctx context.Context, op *vsphere.GuestOperation) error {
+			return errors.New("not on production")
+		},
+		AfterFunc: func(ctx context.Context, op *vsphere.GuestOperation, r *vsphere.GuestResult) {
+			res = r
+		},
+	}, vsphere.GuestHookFuncs{
+		BeforeFunc: func(ctx context.Context, op *vsphere.GuestOperation) error {
+			next = true
+			return nil
+		},
+	})
+
+	_, err := c.RunCmdSync(context.Background(), `Remove-Item C:\app`)
+
+	if !errors.Is(err, vsphere.ErrVetoed) {
+		t.Errorf("got %v, want ErrVetoed", err)
+	}
+
+	if n := len(g.Processes()); n != 0 || next {
+		t.Errorf("ran %d programs, later hook called: %t", n, next)
+	}
+
+	// the vetoing hook is told
+	if res == nil || !errors.Is(res.Err, vsphere.ErrVetoed) {
+		t.Errorf("After got %+v", res)
+	}
+}
+
+func TestHooksRewrite(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	c := toolBoxClient(t, s, "DC0_H0_VM0")
+
+	c.Use(vsphere.GuestHookFuncs{
+		BeforeFunc: func(ctx context.Context, op *vsphere.GuestOperation) error {
+			switch op.Kind {
+			case "command":
+				op.Command += " -Format o"
+			case "upload":
+				op.Destination = strings.Replace(op.Destination, `C:\tmp`, `D:\data`, 1)
+			}
+			return nil
+		},
+	})
+
+	ctx := context.Background()
+
+	if _, err := c.RunCmdSync(ctx, "Get-Date"); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := g.Processes(); len(p) != 1 || !strings.Contains(p[0].Command(), "Get-Date -Format o") {
+		t.Errorf("ran %+v", p)
+	}
+
+	if err := c.UploadFile(ctx, `C:\tmp\a.txt`, strings.NewReader("abc"), ".txt", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, ok := g.ReadFile(`D:\data\a.txt`); !ok || string(data) != "abc" {
+		t.Errorf("uploaded %q to the rewritten destination: %t", data, ok)
+	}
+}
+
+func TestHooksAfter(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	g.Handler = func(p *vspheretest.Process) {
+		p.Stdout = "out"
+		if strings.Contains(p.Command(), "exit 2") {
+			p.ExitCode = 2
+		}
+	}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	c := toolBoxClient(t, s, "DC0_H0_VM0")
+
+	var calls []string
+	var results []*vsphere.GuestResult
+
+	hook := func(name string) vsphere.GuestHook {
+		return vsphere.GuestHookFuncs{
+			BeforeFunc: func(ctx context.Context, op *vsphere.GuestOperation) error {
+				calls = append(calls, "before "+name)
+				return nil
+			},
+			AfterFunc: func(ctx context.Context, op *vsphere.GuestOperation, res *vsphere.GuestResult) {
+				calls = append(calls, "after "+name)
+				results = append(results, res)
+			},
+		}
+	}
+
+	c.Use(hook("a"), hook("b"))
+
+	ctx := context.Background()
+
+	if _, err := c.RunCmdSync(ctx, "Get-Date"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(calls, ", "); got != "before a, before b, after b, after a" {
+		t.Errorf("got %s", got)
+	}
+
+	if res := results[0]; res.Output == nil || res.Output.Stdout != "out" || res.Err != nil {
+		t.Errorf("got result %+v", res)
+	}
+
+	if _, err := c.RunCmdSync(ctx, "exit 2"); !errors.Is(err, vsphere.ErrGuestExit) {
+		t.Fatalf("got %v", err)
+	}
+
+	var exit *vsphere.ExitError
+
+	if res := results[len(results)-1]; !errors.As(res.Err, &exit) || exit.ExitCode() != 2 {
+		t.Errorf("got result %+v", res)
+	}
+}
@@ -0,0 +1,30 @@
+package vsphere_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+func TestGetHosts(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{ClusterHosts: 2})
+
+	hosts, err := vsphere.GetHosts(context.Background(), s.Client.Client, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one standalone host and two in the cluster
+	if len(hosts) != 3 {
+		t.Fatalf("got %d hosts, want 3", len(hosts))
+	}
+
+	for _, host := range hosts {
+		if host.Summary.Config.Name == "" || host.Hardware == nil {
+			t.Errorf("host %s: properties not retrieved", host.Self)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/xml"
+)
+
+// Metrics receives one observation per SOAP call, e.g. to feed Prometheus or expvar.
+type Metrics interface {
+	ObserveCall(method string, duration time.Duration, err error)
+}
+
+type MethodStats struct {
+	Calls  int64
+	Faults int64 // calls that returned an error, vim faults included
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// CallStats is a Metrics that keeps per-method counters in memory.
+type CallStats struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+func NewCallStats() *CallStats {
+	return &CallStats{methods: make(map[string]*MethodStats)}
+}
+
+func (s *CallStats) ObserveCall(method string, duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.methods[method]
+
+	if !ok {
+		m = new(MethodStats)
+		s.methods[method] = m
+	}
+
+	m.Calls++
+	m.Total += duration
+
+	if duration > m.Max {
+		m.Max = duration
+	}
+
+	if err != nil {
+		m.Faults++
+	}
+}
+
+// Snapshot returns a copy of the counters by method name.
+func (s *CallStats) Snapshot() map[string]MethodStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make(map[string]MethodStats, len(s.methods))
+
+	for name, m := range s.methods {
+		snapshot[name] = *m
+	}
+
+	return snapshot
+}
+
+func (s *CallStats) String() string {
+
+	snapshot := s.Snapshot()
+
+	names := make([]string, 0, len(snapshot))
+
+	for name := range snapshot {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var b strings.Builder
+
+	for _, name := range names {
+		m := snapshot[name]
+		fmt.Fprintf(&b, "%s: %d calls, %d faults, avg %s, max %s\n",
+			name, m.Calls, m.Faults, m.Total/time.Duration(m.Calls), m.Max)
+	}
+
+	return b.String()
+}
+
+// InstrumentOptions selects what Instrument adds to a client; zero fields are disabled.
+type InstrumentOptions struct {
+	Metrics Metrics
+
+	// Trace receives the request and response body of every call, with passwords,
+	// tokens and registered secrets masked.
+	Trace io.Writer
+
+	// RateLimit caps the calls per second; Burst calls may go out back to back after an idle period.
+	RateLimit float64
+	Burst     int
+}
+
+// Instrument wraps the RoundTripper of c, so it applies to every call made through c,
+// including the login of an automatic re-login. A call retried after the re-login is
+// observed once, with its final result. The pings of the session keep-alive are not
+// observed: the keep-alive handler sits below the instrumented RoundTripper.
+func Instrument(c *vim25.Client, opts InstrumentOptions) {
+
+	rt := &instrumentedRoundTripper{
+		RoundTripper: c.RoundTripper,
+		metrics:      opts.Metrics,
+		trace:        opts.Trace,
+	}
+
+	if opts.RateLimit > 0 {
+		rt.limiter = newLimiter(opts.RateLimit, opts.Burst)
+	}
+
+	c.RoundTripper = rt
+}
+
+type instrumentedRoundTripper struct {
+	soap.RoundTripper
+
+	metrics Metrics
+	limiter *limiter
+
+	traceMu sync.Mutex
+	trace   io.Writer
+}
+
+func (rt *instrumentedRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+
+	if rt.limiter != nil {
+		if err := rt.limiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	method := soapMethod(req)
+	start := time.Now()
+
+	err := rt.RoundTripper.RoundTrip(ctx, req, res)
+
+	duration := time.Since(start)
+
+	if rt.metrics != nil {
+		rt.metrics.ObserveCall(method, duration, err)
+	}
+
+	if rt.trace != nil {
+		rt.writeTrace(method, duration, req, res, err)
+	}
+
+	return err
+}
+
+func (rt *instrumentedRoundTripper) writeTrace(method string, duration time.Duration, req, res soap.HasFault, err error) {
+
+	rt.traceMu.Lock()
+	defer rt.traceMu.Unlock()
+
+	fmt.Fprintf(rt.trace, "--> %s\n%s\n", method, traceBody(req))
+
+	if err != nil {
+		fmt.Fprintf(rt.trace, "<-- %s %s error: %s\n\n", method, duration, DefaultRedactor.Redact(err.Error()))
+		return
+	}
+
+	fmt.Fprintf(rt.trace, "<-- %s %s\n%s\n\n", method, duration, traceBody(res))
+}
+
+// traceSecrets matches the elements that carry credentials in vim25 requests.
+var traceSecrets = regexp.MustCompile(`(?is)<((?:\w+:)?(?:password|token|ticket|sessionKey|privateKey|Assertion))(\s[^>]*)?>.*?</(?:\w+:)?(?:password|token|ticket|sessionKey|privateKey|Assertion)>`)
+
+func traceBody(body interface{}) string {
+
+	b, err := xml.Marshal(body)
+
+	if err != nil {
+		return fmt.Sprintf("(%s)", err)
+	}
+
+	s := traceSecrets.ReplaceAllString(string(b), "<$1$2>"+secretMask+"</$1>")
+
+	return DefaultRedactor.Redact(s)
+}
+
+// soapMethod names the vim25 method of a request body, e.g. "RetrievePropertiesEx".
+func soapMethod(req soap.HasFault) string {
+
+	t := reflect.TypeOf(req)
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return strings.TrimSuffix(t.Name(), "Body")
+}
+
+// limiter spaces calls 1/rate apart, letting up to burst calls through at once after an idle period.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	next     time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &limiter{interval: time.Duration(float64(time.Second) / rate), burst: burst}
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+
+	l.mu.Lock()
+
+	now := time.Now()
+
+	if earliest := now.Add(-time.Duration(l.burst-1) * l.interval); l.next.Before(earliest) {
+		l.next = earliest
+	}
+
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
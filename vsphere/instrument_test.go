@@ -0,0 +1,176 @@
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestCallStats(t *testing.T) {
+
+	s := NewCallStats()
+
+	s.ObserveCall("RetrievePropertiesEx", 10*time.Millisecond, nil)
+	s.ObserveCall("RetrievePropertiesEx", 30*time.Millisecond, errors.New("fault"))
+	s.ObserveCall("Login", 5*time.Millisecond, nil)
+
+	snapshot := s.Snapshot()
+
+	want := MethodStats{Calls: 2, Faults: 1, Total: 40 * time.Millisecond, Max: 30 * time.Millisecond}
+
+	if got := snapshot["RetrievePropertiesEx"]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// the snapshot is a copy
+	s.ObserveCall("Login", time.Millisecond, nil)
+
+	if snapshot["Login"].Calls != 1 {
+		t.Error("snapshot changed")
+	}
+
+	wantString := "Login: 2 calls, 0 faults, avg 3ms, max 5ms\nRetrievePropertiesEx: 2 calls, 1 faults, avg 20ms, max 30ms\n"
+
+	if got := s.String(); got != wantString {
+		t.Errorf("got %q, want %q", got, wantString)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+
+	ctx := context.Background()
+
+	l := newLimiter(20, 3) // 50ms apart
+
+	start := time.Now()
+
+	for i := 0; i < 4; i++ {
+		if err := l.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		elapsed := time.Since(start)
+
+		switch {
+		case i < 3 && elapsed > 25*time.Millisecond:
+			t.Errorf("call %d of the burst waited %s", i, elapsed)
+		case i == 3 && elapsed < 40*time.Millisecond:
+			t.Errorf("call after the burst waited only %s", elapsed)
+		}
+	}
+
+	if l := newLimiter(1, 0); l.burst != 1 || l.interval != time.Second {
+		t.Errorf("got burst %d, interval %s", l.burst, l.interval)
+	}
+
+	l = newLimiter(1, 1)
+	_ = l.wait(ctx)
+
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.wait(cancelled); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context error", err)
+	}
+}
+
+func TestTraceBody(t *testing.T) {
+
+	RegisterSecret("registered-secret-value")
+
+	body := &methods.LoginBody{Req: &types.Login{
+		This:     types.ManagedObjectReference{Type: "SessionManager", Value: "SessionManager"},
+		UserName: "administrator",
+		Password: "s3cret",
+		Locale:   "registered-secret-value",
+	}}
+
+	got := traceBody(body)
+
+	if strings.Contains(got, "s3cret") || strings.Contains(got, "registered-secret-value") {
+		t.Errorf("secret in trace: %s", got)
+	}
+
+	if !strings.Contains(got, "<password>"+secretMask+"</password>") || !strings.Contains(got, "administrator") {
+		t.Errorf("got %s", got)
+	}
+
+	token := `<saml2:Assertion ID="_1" xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion"><saml2:Subject>user</saml2:Subject></saml2:Assertion>`
+
+	if got := traceSecrets.ReplaceAllString(token, "<$1$2>"+secretMask+"</$1>"); strings.Contains(got, "Subject") {
+		t.Errorf("assertion in trace: %s", got)
+	}
+}
+
+func TestInstrument(t *testing.T) {
+
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+
+		stats := NewCallStats()
+		var trace bytes.Buffer
+
+		Instrument(c, InstrumentOptions{Metrics: stats, Trace: &trace})
+
+		if _, err := methods.GetCurrentTime(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := stats.Snapshot()["CurrentTime"]; got.Calls != 1 || got.Faults != 0 {
+			t.Errorf("got %+v", got)
+		}
+
+		if !strings.Contains(trace.String(), "--> CurrentTime") || !strings.Contains(trace.String(), "<-- CurrentTime") {
+			t.Errorf("got trace %s", trace.String())
+		}
+	})
+}
+
+func TestInstrumentSession(t *testing.T) {
+
+	simulator.Test(func(ctx context.Context, c *vim25.Client) {
+
+		u := *c.URL()
+		u.User = nil
+
+		vc, err := NewClientWithConfig(ctx, ClientConfig{URL: u.String(), Username: "user", Password: "pass", Insecure: true, KeepAlive: 10 * time.Millisecond})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats := NewCallStats()
+
+		Instrument(vc.Client, InstrumentOptions{Metrics: stats})
+
+		// keep-alive pings bypass the instrumented RoundTripper
+		time.Sleep(50 * time.Millisecond)
+
+		if n := len(stats.Snapshot()); n != 0 {
+			t.Errorf("observed %d methods before any call", n)
+		}
+
+		if err := session.NewManager(vc.Client).Logout(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := methods.GetCurrentTime(ctx, vc.Client); err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := stats.Snapshot()
+
+		// the re-login is observed, and the retried call once with its final result
+		if snapshot["Login"].Calls != 1 || snapshot["CurrentTime"].Calls != 1 || snapshot["CurrentTime"].Faults != 0 {
+			t.Errorf("got %+v", snapshot)
+		}
+	})
+}
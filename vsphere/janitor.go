@@ -0,0 +1,151 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// DefaultTempPrefix names the guest temp files of a ToolBoxClient without a TempPrefix.
+const DefaultTempPrefix = "govmomi-"
+
+func (c *ToolBoxClient) tempPrefix() string {
+	if c.TempPrefix == "" {
+		return DefaultTempPrefix
+	}
+	return c.TempPrefix
+}
+
+// CleanupTempFiles removes files starting with the client's TempPrefix from its TempDir, or
+// the guest's %TEMP%, that were last modified more than olderThan ago. Such files are left
+// behind when the guest reboots or the process dies before the deferred removal ran.
+// It returns the paths removed; files that could not be removed are reported in the error.
+func (c *ToolBoxClient) CleanupTempFiles(ctx context.Context, olderThan time.Duration) ([]string, error) {
+
+	dir, err := c.guestTempDir(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := "^" + regexp.QuoteMeta(c.tempPrefix())
+	cutoff := time.Now().Add(-olderThan)
+
+	var stale []string
+	var index int32
+
+	for {
+		list, err := c.FileManager.ListFiles(ctx, c.Authentication, dir, index, 0, pattern)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range list.Files {
+			if f.Type != string(types.GuestFileTypeFile) {
+				continue
+			}
+
+			if f.Attributes == nil {
+				continue
+			}
+
+			modified := f.Attributes.GetGuestFileAttributes().ModificationTime
+
+			if modified == nil || modified.After(cutoff) {
+				continue
+			}
+
+			stale = append(stale, guestJoin(dir, f.Path))
+		}
+
+		index += int32(len(list.Files))
+
+		if list.Remaining == 0 || len(list.Files) == 0 {
+			break
+		}
+	}
+
+	var removed []string
+	var failed []string
+
+	for _, path := range stale {
+		if c.DryRun != nil {
+			c.DryRun.add(PlannedStep{Kind: "remove", VM: c.VMName, Destination: path})
+			removed = append(removed, path)
+			continue
+		}
+
+		if err := c.FileManager.DeleteFile(ctx, c.Authentication, path); err != nil {
+			logWarn("removing stale guest file failed", "vm", c.VMName, "path", path, "error", err)
+			failed = append(failed, path)
+			continue
+		}
+
+		logInfo("removed stale guest file", "vm", c.VMName, "path", path)
+		removed = append(removed, path)
+	}
+
+	if len(failed) != 0 {
+		return removed, fmt.Errorf("could not remove %d stale guest files: %s", len(failed), strings.Join(failed, ", "))
+	}
+
+	return removed, nil
+}
+
+// CleanupGuestTempFiles runs CleanupTempFiles on vmName; the "tempPrefix" and "tempDir"
+// options select the files the same way they do for InvokeCommands.
+func CleanupGuestTempFiles(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, olderThan time.Duration, options map[string]interface{}) (removed []string, err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(vmName, err))
+	}()
+
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tboxClient.ReleaseCredentials(ctx)
+
+	if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+		return nil, err
+	}
+
+	return tboxClient.CleanupTempFiles(ctx, olderThan)
+}
+
+func (c *ToolBoxClient) guestTempDir(ctx context.Context) (string, error) {
+
+	if c.TempDir != "" {
+		return c.TempDir, nil
+	}
+
+	vars, err := c.ProcessManager.ReadEnvironmentVariable(ctx, c.Authentication, []string{"TEMP"})
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, v := range vars {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "TEMP") && kv[1] != "" {
+			return kv[1], nil
+		}
+	}
+
+	return "", fmt.Errorf("guest has no TEMP environment variable, set TempDir")
+}
+
+// guestJoin joins a file name returned by ListFiles to its Windows directory.
+func guestJoin(dir, name string) string {
+	if strings.ContainsAny(name, `\/`) {
+		return name
+	}
+	return strings.TrimRight(dir, `\`) + `\` + name
+}
@@ -0,0 +1,89 @@
+package vsphere_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+func TestCleanupGuestTempFiles(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	stale := time.Now().Add(-48 * time.Hour)
+
+	for _, name := range []string{`govmomi-old.txt`, `govmomi-new.txt`, `other-old.txt`, `app-old.txt`} {
+		g.WriteFile(vspheretest.DefaultTempDir+`\`+name, nil)
+
+		if strings.Contains(name, "old") {
+			g.Touch(vspheretest.DefaultTempDir+`\`+name, stale)
+		}
+	}
+
+	g.WriteFile(`C:\app\app-old.txt`, nil)
+	g.Touch(`C:\app\app-old.txt`, stale)
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	removed, err := vsphere.CleanupGuestTempFiles(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret", 24*time.Hour, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := vspheretest.DefaultTempDir + `\govmomi-old.txt`; len(removed) != 1 || removed[0] != want {
+		t.Errorf("removed %q, want %s", removed, want)
+	}
+
+	// a custom prefix and dir only touch the files of that client
+	removed, err = vsphere.CleanupGuestTempFiles(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret", 24*time.Hour,
+		map[string]interface{}{"tempPrefix": "app-", "tempDir": `C:\app`})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 1 || removed[0] != `C:\app\app-old.txt` {
+		t.Errorf("removed %q", removed)
+	}
+
+	want := []string{
+		`C:\Windows\Temp\app-old.txt`,
+		`C:\Windows\Temp\govmomi-new.txt`,
+		`C:\Windows\Temp\other-old.txt`,
+	}
+
+	if got := g.Files(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("left %q, want %q", got, want)
+	}
+}
+
+func TestCleanupGuestTempFilesDryRun(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+	g.WriteFile(vspheretest.DefaultTempDir+`\govmomi-old.txt`, nil)
+	g.Touch(vspheretest.DefaultTempDir+`\govmomi-old.txt`, time.Now().Add(-48*time.Hour))
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	plan := new(vsphere.Plan)
+
+	removed, err := vsphere.CleanupGuestTempFiles(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret", time.Hour,
+		map[string]interface{}{"dryRun": plan})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 1 || len(plan.Steps) != 1 || plan.Steps[0].Kind != "remove" {
+		t.Errorf("removed %q, planned:\n%s", removed, plan)
+	}
+
+	if len(g.Files()) != 1 {
+		t.Error("dry run removed the file")
+	}
+}
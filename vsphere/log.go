@@ -0,0 +1,130 @@
+package vsphere
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Logger receives the package's log lines. keyvals alternate between string keys
+// and values, e.g. "vm", "web-01", "pid", 4242.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(LogLevel, string, ...interface{}) {}
+
+var (
+	loggerMu sync.RWMutex
+	logger   Logger = nopLogger{}
+)
+
+// SetLogger installs l for all package logging; nil restores the default of no output.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+
+	logger = l
+}
+
+// StdLogger writes "level msg key=value ..." lines at or above Level to a *log.Logger.
+type StdLogger struct {
+	Logger *log.Logger
+	Level  LogLevel
+}
+
+func (s StdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < s.Level {
+		return
+	}
+
+	var b strings.Builder
+
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%q", keyvals[i], fmt.Sprint(v))
+	}
+
+	l := s.Logger
+	if l == nil {
+		l = log.New(log.Writer(), "", log.LstdFlags)
+	}
+
+	l.Print(b.String())
+}
+
+func logDebug(msg string, keyvals ...interface{}) {
+	logAt(LevelDebug, msg, keyvals...)
+}
+
+func logInfo(msg string, keyvals ...interface{}) {
+	logAt(LevelInfo, msg, keyvals...)
+}
+
+func logWarn(msg string, keyvals ...interface{}) {
+	logAt(LevelWarn, msg, keyvals...)
+}
+
+// logAt masks secrets in the message and in string and error values before handing them on.
+func logAt(level LogLevel, msg string, keyvals ...interface{}) {
+
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+
+	if _, ok := l.(nopLogger); ok {
+		return
+	}
+
+	kv := make([]interface{}, len(keyvals))
+
+	for i, v := range keyvals {
+		switch v := v.(type) {
+		case string:
+			kv[i] = DefaultRedactor.Redact(v)
+		case error:
+			kv[i] = DefaultRedactor.Redact(v.Error())
+		default:
+			kv[i] = v
+		}
+	}
+
+	l.Log(level, DefaultRedactor.Redact(msg), kv...)
+}
@@ -0,0 +1,95 @@
+package vsphere
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type logEntry struct {
+	level   LogLevel
+	msg     string
+	keyvals []interface{}
+}
+
+// recordingLogger keeps the lines logged to it.
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (r *recordingLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, logEntry{level, msg, keyvals})
+}
+
+// useLogger installs l for the rest of the test.
+func useLogger(t *testing.T, l Logger) {
+	SetLogger(l)
+	t.Cleanup(func() { SetLogger(nil) })
+}
+
+func TestLogDefaultSilent(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	out := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(out)
+
+	logWarn("not written", "vm", "web-01")
+
+	if buf.Len() != 0 {
+		t.Errorf("default logger wrote %q", buf.String())
+	}
+
+	// a StdLogger without a *log.Logger writes to the same output
+	useLogger(t, StdLogger{})
+
+	logWarn("written", "vm", "web-01")
+
+	if buf.Len() == 0 {
+		t.Error("StdLogger wrote nothing")
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	l := StdLogger{Logger: log.New(&buf, "", 0), Level: LevelInfo}
+
+	l.Log(LevelDebug, "filtered", "vm", "web-01")
+	l.Log(LevelWarn, "guest not ready", "vm", "web 01", "pid", 4242, "attempt")
+
+	if want := "warn guest not ready vm=\"web 01\" pid=\"4242\" attempt=\"(missing)\"\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLogMasksSecrets(t *testing.T) {
+
+	RegisterSecret("logged-secret-value")
+
+	rec := new(recordingLogger)
+	useLogger(t, rec)
+
+	logInfo("login with logged-secret-value", "password", "logged-secret-value",
+		"error", errors.New("bad password logged-secret-value"), "attempt", 2)
+
+	want := []logEntry{{
+		level: LevelInfo,
+		msg:   "login with " + secretMask,
+		keyvals: []interface{}{"password", secretMask,
+			"error", "bad password " + secretMask, "attempt", 2},
+	}}
+
+	if !reflect.DeepEqual(rec.entries, want) {
+		t.Errorf("got %+v, want %+v", rec.entries, want)
+	}
+}
@@ -0,0 +1,426 @@
+package vsphere
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"gopkg.in/yaml.v2"
+)
+
+// Profile is one named connection in a profile file, in YAML or JSON:
+//
+//	default: lab
+//	profiles:
+//	  lab:
+//	    url: https://vcenter.lab/sdk
+//	    username: administrator@vsphere.local
+//	    password: secret
+//	    caFile: /etc/ssl/lab-ca.pem
+//	    keepAlive: 10m
+type Profile struct {
+	URL          string            `yaml:"url" json:"url"`
+	Username     string            `yaml:"username" json:"username"`
+	Password     string            `yaml:"password" json:"password"`
+	Insecure     bool              `yaml:"insecure" json:"insecure"`
+	CAFile       string            `yaml:"caFile" json:"caFile"`
+	Thumbprints  map[string]string `yaml:"thumbprints" json:"thumbprints"`
+	SessionCache bool              `yaml:"sessionCache" json:"sessionCache"`
+	SessionDir   string            `yaml:"sessionDir" json:"sessionDir"`
+	KeepAlive    string            `yaml:"keepAlive" json:"keepAlive"` // a time.ParseDuration string
+	CertFile     string            `yaml:"certFile" json:"certFile"`   // solution user certificate, with KeyFile
+	KeyFile      string            `yaml:"keyFile" json:"keyFile"`
+	SSOToken     bool              `yaml:"ssoToken" json:"ssoToken"`
+}
+
+type ProfileFile struct {
+	Default  string             `yaml:"default" json:"default"`
+	Profiles map[string]Profile `yaml:"profiles" json:"profiles"`
+}
+
+// ConfigError reports an invalid or missing ClientConfig field and where its value came from.
+type ConfigError struct {
+	Field  string // e.g. "url"
+	Source string // e.g. "GOVC_URL" or `profile "lab" in ~/.vsphere.yaml`; empty when the field is missing
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Source == "" {
+		return fmt.Sprintf("client config: %s: %s", e.Field, e.Err)
+	}
+	return fmt.Sprintf("client config: %s (from %s): %s", e.Field, e.Source, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigLoader builds a ClientConfig from, in increasing precedence, a profile of File,
+// the GOVC_* environment variables govc uses, and Overrides. Zero override fields are ignored.
+type ConfigLoader struct {
+	File    string // profile file, skipped when empty
+	Profile string // profile name, defaults to the file's default profile
+	NoEnv   bool   // ignore the environment
+
+	Overrides ClientConfig
+}
+
+// LoadClientConfig loads the configuration from the environment and overrides only.
+func LoadClientConfig(overrides ClientConfig) (ClientConfig, error) {
+	return ConfigLoader{Overrides: overrides}.Load()
+}
+
+func (l ConfigLoader) Load() (ClientConfig, error) {
+
+	var cfg ClientConfig
+
+	sources := make(map[string]string)
+
+	if l.File != "" {
+		if err := l.loadProfile(&cfg, sources); err != nil {
+			return cfg, err
+		}
+	}
+
+	if !l.NoEnv {
+		if err := loadEnv(&cfg, sources); err != nil {
+			return cfg, err
+		}
+	}
+
+	applyOverrides(&cfg, l.Overrides, sources)
+
+	if err := splitURLUser(&cfg, sources); err != nil {
+		return cfg, err
+	}
+
+	return cfg, validateConfig(cfg, sources)
+}
+
+// Validate checks that cfg has everything NewClientWithConfig needs.
+func (cfg ClientConfig) Validate() error {
+	return validateConfig(cfg, nil)
+}
+
+func (l ConfigLoader) loadProfile(cfg *ClientConfig, sources map[string]string) error {
+
+	b, err := ioutil.ReadFile(l.File)
+
+	if err != nil {
+		return err
+	}
+
+	var f ProfileFile
+
+	switch strings.ToLower(filepath.Ext(l.File)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&f)
+	default:
+		err = yaml.UnmarshalStrict(b, &f)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %s", l.File, err)
+	}
+
+	name := l.Profile
+
+	if name == "" {
+		name = f.Default
+	}
+
+	if name == "" && len(f.Profiles) == 1 {
+		for name = range f.Profiles {
+		}
+	}
+
+	p, ok := f.Profiles[name]
+
+	if !ok {
+		return fmt.Errorf("%s: no profile %q", l.File, name)
+	}
+
+	source := fmt.Sprintf("profile %q in %s", name, l.File)
+
+	set := func(field string, ok bool) {
+		if ok {
+			sources[field] = source
+		}
+	}
+
+	cfg.URL, cfg.Username, cfg.Password = p.URL, p.Username, p.Password
+	cfg.Insecure, cfg.CAFile, cfg.Thumbprints = p.Insecure, p.CAFile, p.Thumbprints
+	cfg.SessionCache, cfg.SessionDir = p.SessionCache, p.SessionDir
+
+	set("url", p.URL != "")
+	set("username", p.Username != "")
+	set("password", p.Password != "")
+	set("caFile", p.CAFile != "")
+	set("thumbprints", len(p.Thumbprints) != 0)
+	set("sessionDir", p.SessionDir != "")
+
+	cfg.SSOToken = p.SSOToken
+
+	if p.CertFile != "" || p.KeyFile != "" {
+		cert, err := LoadSolutionUser(p.CertFile, p.KeyFile)
+
+		if err != nil {
+			return &ConfigError{Field: "certificate", Source: source, Err: err}
+		}
+
+		cfg.Certificate = cert
+		set("certificate", true)
+	}
+
+	if p.KeepAlive != "" {
+		d, err := time.ParseDuration(p.KeepAlive)
+
+		if err != nil {
+			return &ConfigError{Field: "keepAlive", Source: source, Err: err}
+		}
+
+		cfg.KeepAlive = d
+		set("keepAlive", true)
+	}
+
+	return nil
+}
+
+// loadEnv reads the variables govc uses; GOVC_TLS_KNOWN_HOSTS names a file of "host thumbprint"
+// lines and GOVC_CERTIFICATE and GOVC_PRIVATE_KEY the PEM files of a solution user.
+func loadEnv(cfg *ClientConfig, sources map[string]string) error {
+
+	str := func(name, field string, v *string) {
+		if s := os.Getenv(name); s != "" {
+			*v = s
+			sources[field] = name
+		}
+	}
+
+	flag := func(name, field string, v *bool) error {
+		s := os.Getenv(name)
+
+		if s == "" {
+			return nil
+		}
+
+		b, err := strconv.ParseBool(s)
+
+		if err != nil {
+			return &ConfigError{Field: field, Source: name, Err: err}
+		}
+
+		*v = b
+		sources[field] = name
+
+		return nil
+	}
+
+	str("GOVC_URL", "url", &cfg.URL)
+	str("GOVC_USERNAME", "username", &cfg.Username)
+	str("GOVC_PASSWORD", "password", &cfg.Password)
+	str("GOVC_TLS_CA_CERTS", "caFile", &cfg.CAFile)
+
+	if err := flag("GOVC_INSECURE", "insecure", &cfg.Insecure); err != nil {
+		return err
+	}
+
+	if err := flag("GOVC_PERSIST_SESSION", "sessionCache", &cfg.SessionCache); err != nil {
+		return err
+	}
+
+	if certFile := os.Getenv("GOVC_CERTIFICATE"); certFile != "" {
+		cert, err := LoadSolutionUser(certFile, os.Getenv("GOVC_PRIVATE_KEY"))
+
+		if err != nil {
+			return &ConfigError{Field: "certificate", Source: "GOVC_CERTIFICATE", Err: err}
+		}
+
+		cfg.Certificate = cert
+		sources["certificate"] = "GOVC_CERTIFICATE"
+	}
+
+	if file := os.Getenv("GOVC_TLS_KNOWN_HOSTS"); file != "" {
+		thumbprints, err := readKnownHosts(file)
+
+		if err != nil {
+			return &ConfigError{Field: "thumbprints", Source: "GOVC_TLS_KNOWN_HOSTS", Err: err}
+		}
+
+		cfg.Thumbprints = thumbprints
+		sources["thumbprints"] = "GOVC_TLS_KNOWN_HOSTS"
+	}
+
+	return nil
+}
+
+func applyOverrides(cfg *ClientConfig, o ClientConfig, sources map[string]string) {
+
+	str := func(field string, v *string, override string) {
+		if override != "" {
+			*v = override
+			sources[field] = "overrides"
+		}
+	}
+
+	str("url", &cfg.URL, o.URL)
+	str("username", &cfg.Username, o.Username)
+	str("password", &cfg.Password, o.Password)
+	str("caFile", &cfg.CAFile, o.CAFile)
+	str("sessionDir", &cfg.SessionDir, o.SessionDir)
+
+	if o.Insecure {
+		cfg.Insecure = true
+		sources["insecure"] = "overrides"
+	}
+
+	if o.SessionCache {
+		cfg.SessionCache = true
+		sources["sessionCache"] = "overrides"
+	}
+
+	if len(o.Thumbprints) != 0 {
+		cfg.Thumbprints = o.Thumbprints
+		sources["thumbprints"] = "overrides"
+	}
+
+	str("token", &cfg.Token, o.Token)
+
+	if o.Certificate != nil {
+		cfg.Certificate = o.Certificate
+		sources["certificate"] = "overrides"
+	}
+
+	if o.SSOToken {
+		cfg.SSOToken = true
+	}
+
+	if o.TokenLifetime != 0 {
+		cfg.TokenLifetime = o.TokenLifetime
+	}
+
+	if o.KeepAlive != 0 {
+		cfg.KeepAlive = o.KeepAlive
+		sources["keepAlive"] = "overrides"
+	}
+
+	str("record", &cfg.Record, o.Record)
+	str("replay", &cfg.Replay, o.Replay)
+}
+
+// splitURLUser moves credentials embedded in the URL, as GOVC_URL allows, into the
+// username and password fields unless those were set separately.
+func splitURLUser(cfg *ClientConfig, sources map[string]string) error {
+
+	if cfg.URL == "" {
+		return nil
+	}
+
+	u, err := soap.ParseURL(cfg.URL)
+
+	if err != nil {
+		return &ConfigError{Field: "url", Source: sources["url"], Err: err}
+	}
+
+	if u.User == nil || u.User.Username() == "" {
+		return nil
+	}
+
+	if cfg.Username == "" {
+		cfg.Username = u.User.Username()
+		sources["username"] = sources["url"]
+	}
+
+	// the password in the URL belongs to the user in the URL only
+	if password, ok := u.User.Password(); ok && cfg.Password == "" && cfg.Username == u.User.Username() {
+		cfg.Password = password
+		sources["password"] = sources["url"]
+	}
+
+	u.User = nil
+	cfg.URL = u.String()
+
+	return nil
+}
+
+func validateConfig(cfg ClientConfig, sources map[string]string) error {
+
+	missing := func(field, reason, env, name string) error {
+		return &ConfigError{Field: field, Err: fmt.Errorf("required%s; set %s, %s in the profile or ClientConfig.%s", reason, env, field, name)}
+	}
+
+	// NewClientWithConfig takes the URL of a replay from the recording
+	if cfg.URL == "" && cfg.Replay == "" {
+		return missing("url", "", "GOVC_URL", "URL")
+	}
+
+	if _, err := soap.ParseURL(cfg.URL); err != nil {
+		return &ConfigError{Field: "url", Source: sources["url"], Err: err}
+	}
+
+	if cfg.SSOToken && cfg.Username == "" && cfg.Token == "" && cfg.Certificate == nil {
+		return missing("username", " for an SSO token", "GOVC_USERNAME", "Username")
+	}
+
+	if cfg.Password != "" && cfg.Username == "" {
+		return missing("username", " when a password is set", "GOVC_USERNAME", "Username")
+	}
+
+	if cfg.Username != "" && cfg.Password == "" && cfg.Token == "" && cfg.Certificate == nil {
+		return missing("password", fmt.Sprintf(" for username %q", cfg.Username), "GOVC_PASSWORD", "Password")
+	}
+
+	if cfg.CAFile != "" {
+		for _, name := range filepath.SplitList(cfg.CAFile) {
+			if _, err := os.Stat(name); err != nil {
+				return &ConfigError{Field: "caFile", Source: sources["caFile"], Err: err}
+			}
+		}
+	}
+
+	if _, err := parseThumbprints(cfg.Thumbprints); err != nil {
+		return &ConfigError{Field: "thumbprints", Source: sources["thumbprints"], Err: err}
+	}
+
+	if cfg.KeepAlive < 0 {
+		return &ConfigError{Field: "keepAlive", Source: sources["keepAlive"], Err: fmt.Errorf("must not be negative")}
+	}
+
+	return nil
+}
+
+func readKnownHosts(file string) (map[string]string, error) {
+
+	f, err := os.Open(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	thumbprints := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 {
+			thumbprints[fields[0]] = fields[1]
+		}
+	}
+
+	return thumbprints, scanner.Err()
+}
@@ -0,0 +1,157 @@
+package vsphere
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const secretMask = "********"
+
+// Redactor masks registered secret values and patterns in strings, errors and outputs.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  []string
+	patterns []*regexp.Regexp
+}
+
+// DefaultRedactor is applied to every output, error and log line produced by the package.
+var DefaultRedactor = NewRedactor()
+
+func NewRedactor(secrets ...string) *Redactor {
+	r := new(Redactor)
+	for _, s := range secrets {
+		r.AddSecret(s)
+	}
+	return r
+}
+
+// RegisterSecret masks s in everything the package emits.
+func RegisterSecret(s string) {
+	DefaultRedactor.AddSecret(s)
+}
+
+// RegisterSecretPattern masks every match of the regular expression pattern.
+func RegisterSecretPattern(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	DefaultRedactor.AddPattern(re)
+	return nil
+}
+
+func (r *Redactor) AddSecret(s string) {
+	if s == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range r.secrets {
+		if secret == s {
+			return
+		}
+	}
+
+	r.secrets = append(r.secrets, s)
+}
+
+func (r *Redactor) AddPattern(re *regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.patterns = append(r.patterns, re)
+}
+
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.Replace(s, secret, secretMask, -1)
+	}
+
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, secretMask)
+	}
+
+	return s
+}
+
+// Error masks the message of err. err itself is returned when nothing was masked,
+// otherwise the original error stays reachable through errors.Unwrap.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := r.Redact(err.Error())
+
+	if msg == err.Error() {
+		return err
+	}
+
+	return &redactedError{err: err, msg: msg}
+}
+
+func (r *Redactor) Output(o terraform.UIOutput) terraform.UIOutput {
+	if o == nil {
+		return nil
+	}
+	return &redactedOutput{o: o, redact: r.Redact}
+}
+
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+type redactedOutput struct {
+	o      terraform.UIOutput
+	redact func(string) string
+}
+
+func (r *redactedOutput) Output(s string) {
+	r.o.Output(r.redact(s))
+}
+
+// lineOutput passes text on to o a line at a time, holding back the part after the
+// last line break until more text or Flush completes it.
+type lineOutput struct {
+	o       terraform.UIOutput
+	partial string
+}
+
+func (l *lineOutput) Output(s string) {
+
+	s = l.partial + s
+
+	i := strings.LastIndexByte(s, '\n')
+
+	l.partial = s[i+1:]
+
+	if i >= 0 {
+		l.o.Output(s[:i+1])
+	}
+}
+
+// Flush passes on the text held back, if any.
+func (l *lineOutput) Flush() {
+
+	if l.partial != "" {
+		l.o.Output(l.partial)
+		l.partial = ""
+	}
+}
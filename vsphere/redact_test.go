@@ -0,0 +1,81 @@
+package vsphere
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type lines []string
+
+func (l *lines) Output(s string) {
+	*l = append(*l, s)
+}
+
+func TestRedact(t *testing.T) {
+
+	r := NewRedactor("s3cret", "")
+	r.AddPattern(regexp.MustCompile(`token=\w+`))
+
+	if got := r.Redact("password s3cret, token=abc123"); got != "password ********, ********" {
+		t.Errorf("got %q", got)
+	}
+
+	err := errors.New("login with s3cret failed")
+	redacted := r.Error(err)
+
+	if redacted.Error() != "login with ******** failed" || !errors.Is(redacted, err) {
+		t.Errorf("got %v", redacted)
+	}
+
+	if clean := errors.New("no secret"); r.Error(clean) != clean {
+		t.Error("error without secrets was wrapped")
+	}
+}
+
+func TestRedactChunkedOutput(t *testing.T) {
+
+	r := NewRedactor("s3cret")
+	r.AddPattern(regexp.MustCompile(`token=\w+`))
+
+	var out lines
+	stdout := &lineOutput{o: r.Output(&out)}
+
+	for _, chunk := range []string{"password s3", "cret\r\ntok", "", "en=abc", "123\r\nlast s3c", "ret"} {
+		stdout.Output(chunk)
+	}
+
+	if len(out) != 2 {
+		t.Errorf("partial lines passed on: %q", out)
+	}
+
+	stdout.Flush()
+	stdout.Flush()
+
+	got := strings.Join(out, "")
+
+	if got != "password ********\r\n********\r\nlast ********" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLineOutput(t *testing.T) {
+
+	var out lines
+	l := &lineOutput{o: &out}
+
+	l.Output("one\r\ntwo\r\n")
+	l.Output("three")
+
+	if len(out) != 1 || out[0] != "one\r\ntwo\r\n" {
+		t.Errorf("got %q", out)
+	}
+
+	l.Output(" and four\nfive")
+	l.Flush()
+
+	if len(out) != 3 || out[1] != "three and four\n" || out[2] != "five" {
+		t.Errorf("got %q", out)
+	}
+}
@@ -0,0 +1,328 @@
+package vsphere
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type RegistryKey struct {
+	Path        string
+	ClassType   string
+	LastWritten time.Time
+}
+
+// RegistryValue holds one of the GuestRegValue*Spec types as Data,
+// e.g. *types.GuestRegValueDwordSpec for REG_DWORD.
+//
+// vCenter sends REG_BINARY data base64 encoded, which govmomi does not decode; RegistryManager
+// encodes and decodes it, so GuestRegValueBinarySpec values hold the raw bytes.
+type RegistryValue struct {
+	Name string
+	Data types.BaseGuestRegValueDataSpec
+}
+
+// RegistryManager edits the registry of a Windows guest through the
+// GuestWindowsRegistryManager, without starting any guest process.
+type RegistryManager struct {
+	c    *vim25.Client
+	ref  types.ManagedObjectReference
+	vm   types.ManagedObjectReference
+	auth types.BaseGuestAuthentication
+
+	vmName string // reported in errors
+
+	// Wow selects the registry view, defaults to the guest's native bitness.
+	Wow types.GuestRegKeyWowSpec
+
+	// DryRun, when set, records changes in the plan instead of making them.
+	DryRun *Plan
+
+	tboxClient *ToolBoxClient
+}
+
+func NewRegistryManager(ctx context.Context, c *vim25.Client, vm types.ManagedObjectReference, auth types.BaseGuestAuthentication) (*RegistryManager, error) {
+
+	if err := RequireFeature(c, FeatureGuestRegistry); err != nil {
+		return nil, err
+	}
+
+	g, err := guestOperationsManager(ctx, c, "guestWindowsRegistryManager")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if g.GuestWindowsRegistryManager == nil {
+		return nil, &Error{Kind: ErrUnsupported, Err: fmt.Errorf("guest windows registry manager is not available on this vCenter")}
+	}
+
+	return &RegistryManager{
+		c:    c,
+		ref:  *g.GuestWindowsRegistryManager,
+		vm:   vm,
+		auth: auth,
+		Wow:  types.GuestRegKeyWowSpecWOWNative,
+	}, nil
+}
+
+// OpenGuestRegistry resolves vmName, waits for VMware Tools and validates the guest
+// credentials before returning a RegistryManager. Call Close when done.
+func OpenGuestRegistry(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, options map[string]interface{}) (*RegistryManager, error) {
+
+	vm, tboxClient, err := newGuestSession(ctx, c, vmName, guestUser, guestPassword, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := waitForGuest(ctx, vm, tboxClient, options); err != nil {
+		tboxClient.ReleaseCredentials(ctx)
+		return nil, err
+	}
+
+	m, err := NewRegistryManager(ctx, c.Client, vm.Reference(), tboxClient.Authentication)
+
+	if err != nil {
+		tboxClient.ReleaseCredentials(ctx)
+		return nil, err
+	}
+
+	m.vmName = vmName
+	m.tboxClient = tboxClient
+	m.DryRun = tboxClient.DryRun
+
+	return m, nil
+}
+
+// Close releases guest credentials acquired by OpenGuestRegistry.
+func (m *RegistryManager) Close(ctx context.Context) error {
+	if m.tboxClient == nil {
+		return nil
+	}
+	return m.tboxClient.ReleaseCredentials(ctx)
+}
+
+func (m *RegistryManager) CreateKey(ctx context.Context, path string, isVolatile bool) error {
+
+	if m.DryRun != nil {
+		m.plan("create key %s", path)
+		return nil
+	}
+
+	req := types.CreateRegistryKeyInGuest{
+		This:       m.ref,
+		Vm:         m.vm,
+		Auth:       m.auth,
+		KeyName:    m.keyName(path),
+		IsVolatile: isVolatile,
+	}
+
+	_, err := methods.CreateRegistryKeyInGuest(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+func (m *RegistryManager) DeleteKey(ctx context.Context, path string, recursive bool) error {
+
+	if m.DryRun != nil {
+		m.plan("delete key %s (recursive: %t)", path, recursive)
+		return nil
+	}
+
+	req := types.DeleteRegistryKeyInGuest{
+		This:      m.ref,
+		Vm:        m.vm,
+		Auth:      m.auth,
+		KeyName:   m.keyName(path),
+		Recursive: recursive,
+	}
+
+	_, err := methods.DeleteRegistryKeyInGuest(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+// ListKeys returns the subkeys of path; pattern is an optional filter on their names.
+func (m *RegistryManager) ListKeys(ctx context.Context, path string, recursive bool, pattern string) ([]RegistryKey, error) {
+
+	req := types.ListRegistryKeysInGuest{
+		This:         m.ref,
+		Vm:           m.vm,
+		Auth:         m.auth,
+		KeyName:      m.keyName(path),
+		Recursive:    recursive,
+		MatchPattern: pattern,
+	}
+
+	res, err := methods.ListRegistryKeysInGuest(ctx, m.c, &req)
+
+	if err != nil {
+		return nil, guestError(m.vmName, err)
+	}
+
+	var keys []RegistryKey
+
+	for _, record := range res.Returnval {
+		if record.Fault != nil {
+			return nil, fmt.Errorf("listing %s: %s", record.Key.KeyName.RegistryPath, record.Fault.LocalizedMessage)
+		}
+
+		keys = append(keys, RegistryKey{
+			Path:        record.Key.KeyName.RegistryPath,
+			ClassType:   record.Key.ClassType,
+			LastWritten: record.Key.LastWritten,
+		})
+	}
+
+	return keys, nil
+}
+
+// ListValues returns the values of path; pattern is an optional filter on their names.
+func (m *RegistryManager) ListValues(ctx context.Context, path string, expandStrings bool, pattern string) ([]RegistryValue, error) {
+
+	req := types.ListRegistryValuesInGuest{
+		This:          m.ref,
+		Vm:            m.vm,
+		Auth:          m.auth,
+		KeyName:       m.keyName(path),
+		ExpandStrings: expandStrings,
+		MatchPattern:  pattern,
+	}
+
+	res, err := methods.ListRegistryValuesInGuest(ctx, m.c, &req)
+
+	if err != nil {
+		return nil, guestError(m.vmName, err)
+	}
+
+	var values []RegistryValue
+
+	for _, v := range res.Returnval {
+		if b, ok := v.Data.(*types.GuestRegValueBinarySpec); ok {
+			raw, err := base64.StdEncoding.DecodeString(string(b.Value))
+
+			if err != nil {
+				return nil, fmt.Errorf("registry value %s\\%s: %s", path, v.Name.Name, err)
+			}
+
+			b.Value = raw
+		}
+
+		values = append(values, RegistryValue{Name: v.Name.Name, Data: v.Data})
+	}
+
+	return values, nil
+}
+
+func (m *RegistryManager) GetValue(ctx context.Context, path, name string) (*RegistryValue, error) {
+
+	// ListValues takes a regular expression, and value names may contain metacharacters
+	values, err := m.ListValues(ctx, path, false, "^"+regexp.QuoteMeta(name)+"$")
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range values {
+		if values[i].Name == name {
+			return &values[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("registry value %s\\%s does not exist", path, name)
+}
+
+func (m *RegistryManager) SetValue(ctx context.Context, path, name string, data types.BaseGuestRegValueDataSpec) error {
+
+	if m.DryRun != nil {
+		m.plan("set value %s\\%s to %T", path, name, data)
+		return nil
+	}
+
+	if b, ok := data.(*types.GuestRegValueBinarySpec); ok {
+		data = &types.GuestRegValueBinarySpec{Value: []byte(base64.StdEncoding.EncodeToString(b.Value))}
+	}
+
+	req := types.SetRegistryValueInGuest{
+		This: m.ref,
+		Vm:   m.vm,
+		Auth: m.auth,
+		Value: types.GuestRegValueSpec{
+			Name: m.valueName(path, name),
+			Data: data,
+		},
+	}
+
+	_, err := methods.SetRegistryValueInGuest(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+func (m *RegistryManager) SetString(ctx context.Context, path, name, value string) error {
+	return m.SetValue(ctx, path, name, &types.GuestRegValueStringSpec{Value: value})
+}
+
+func (m *RegistryManager) SetExpandString(ctx context.Context, path, name, value string) error {
+	return m.SetValue(ctx, path, name, &types.GuestRegValueExpandStringSpec{Value: value})
+}
+
+func (m *RegistryManager) SetMultiString(ctx context.Context, path, name string, value []string) error {
+	return m.SetValue(ctx, path, name, &types.GuestRegValueMultiStringSpec{Value: value})
+}
+
+func (m *RegistryManager) SetDword(ctx context.Context, path, name string, value int32) error {
+	return m.SetValue(ctx, path, name, &types.GuestRegValueDwordSpec{Value: value})
+}
+
+func (m *RegistryManager) SetQword(ctx context.Context, path, name string, value int64) error {
+	return m.SetValue(ctx, path, name, &types.GuestRegValueQwordSpec{Value: value})
+}
+
+func (m *RegistryManager) SetBinary(ctx context.Context, path, name string, value []byte) error {
+	return m.SetValue(ctx, path, name, &types.GuestRegValueBinarySpec{Value: value})
+}
+
+func (m *RegistryManager) DeleteValue(ctx context.Context, path, name string) error {
+
+	if m.DryRun != nil {
+		m.plan("delete value %s\\%s", path, name)
+		return nil
+	}
+
+	req := types.DeleteRegistryValueInGuest{
+		This:      m.ref,
+		Vm:        m.vm,
+		Auth:      m.auth,
+		ValueName: m.valueName(path, name),
+	}
+
+	_, err := methods.DeleteRegistryValueInGuest(ctx, m.c, &req)
+
+	return guestError(m.vmName, err)
+}
+
+func (m *RegistryManager) plan(format string, args ...interface{}) {
+	m.DryRun.add(PlannedStep{Kind: "registry", VM: m.vm.Value, Description: fmt.Sprintf(format, args...)})
+}
+
+func (m *RegistryManager) keyName(path string) types.GuestRegKeyNameSpec {
+	return types.GuestRegKeyNameSpec{
+		RegistryPath: path,
+		WowBitness:   string(m.Wow),
+	}
+}
+
+func (m *RegistryManager) valueName(path, name string) types.GuestRegValueNameSpec {
+	return types.GuestRegValueNameSpec{
+		KeyName: m.keyName(path),
+		Name:    name,
+	}
+}
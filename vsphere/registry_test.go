@@ -0,0 +1,131 @@
+package vsphere_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestRegistryValues(t *testing.T) {
+
+	g := &vspheretest.Guest{Username: "admin", Password: "secret"}
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	ctx := context.Background()
+
+	m, err := vsphere.OpenGuestRegistry(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer m.Close(ctx)
+
+	const key = `HKLM\SOFTWARE\app`
+
+	if err := m.CreateKey(ctx, key, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		set  func(name string) error
+		want types.BaseGuestRegValueDataSpec
+	}{
+		{"String", func(name string) error { return m.SetString(ctx, key, name, "text") },
+			&types.GuestRegValueStringSpec{Value: "text"}},
+		{"ExpandString", func(name string) error { return m.SetExpandString(ctx, key, name, `%TEMP%\app`) },
+			&types.GuestRegValueExpandStringSpec{Value: `%TEMP%\app`}},
+		{"MultiString", func(name string) error { return m.SetMultiString(ctx, key, name, []string{"a", "b"}) },
+			&types.GuestRegValueMultiStringSpec{Value: []string{"a", "b"}}},
+		{"Dword", func(name string) error { return m.SetDword(ctx, key, name, -1) },
+			&types.GuestRegValueDwordSpec{Value: -1}},
+		{"Qword", func(name string) error { return m.SetQword(ctx, key, name, 1<<40) },
+			&types.GuestRegValueQwordSpec{Value: 1 << 40}},
+		{"Binary", func(name string) error { return m.SetBinary(ctx, key, name, []byte{0, 1, 0xff}) },
+			&types.GuestRegValueBinarySpec{Value: []byte{0, 1, 0xff}}},
+	}
+
+	for _, test := range tests {
+		if err := test.set(test.name); err != nil {
+			t.Fatalf("Set%s: %s", test.name, err)
+		}
+
+		v, err := m.GetValue(ctx, key, test.name)
+
+		if err != nil {
+			t.Fatalf("Set%s: %s", test.name, err)
+		}
+
+		if !reflect.DeepEqual(v.Data, test.want) {
+			t.Errorf("Set%s: got %#v, want %#v", test.name, v.Data, test.want)
+		}
+	}
+
+	values, err := m.ListValues(ctx, key, false, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != len(tests) {
+		t.Errorf("listed %d values, want %d", len(values), len(tests))
+	}
+
+	if err := m.DeleteValue(ctx, key, "Dword"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.GetValue(ctx, key, "Dword"); err == nil {
+		t.Error("got the deleted value")
+	}
+}
+
+func TestRegistryGetValueLiteralName(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	m, err := vsphere.OpenGuestRegistry(ctx, s.Client, "DC0_H0_VM0", "admin", "secret", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer m.Close(ctx)
+
+	const key = `HKCU\Software\Microsoft\Windows NT\CurrentVersion\AppCompatFlags\Layers`
+
+	if err := m.CreateKey(ctx, key, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// value names are literal, not regular expressions
+	names := map[string]string{
+		`C:\Program Files (x86)\app+.exe`: "RUNASADMIN",
+		`C:\Program Files (x86)\appp.exe`: "WIN7RTM",
+	}
+
+	for name, value := range names {
+		if err := m.SetString(ctx, key, name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, value := range names {
+		v, err := m.GetValue(ctx, key, name)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if v.Name != name || v.Data.(*types.GuestRegValueStringSpec).Value != value {
+			t.Errorf("%s: got %s = %#v", name, v.Name, v.Data)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package vsphere
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// Exchange is one recorded HTTP request and response: a SOAP call, or a guest file
+// transfer. Credentials in requests and registered secrets are masked.
+type Exchange struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"` // without query
+	Operation   string `json:"operation,omitempty"`
+	Request     string `json:"request,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Response    string `json:"response,omitempty"`
+	Data        []byte `json:"data,omitempty"` // binary response bodies
+}
+
+func (e *Exchange) key() string {
+	return e.Method + " " + e.URL + " " + e.Operation
+}
+
+// soapOperation matches the first element of a SOAP body, e.g. "RetrievePropertiesEx".
+var soapOperation = regexp.MustCompile(`<(?:\w+:)?Body[^>]*>\s*<(?:\w+:)?(\w+)`)
+
+func operation(body []byte) string {
+	if m := soapOperation.FindSubmatch(body); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+func exchangeURL(u *url.URL) string {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// recording is the JSON lines file the recorders of one client append to.
+type recording struct {
+	mu     sync.Mutex
+	f      *os.File
+	enc    *json.Encoder
+	closed bool
+}
+
+func openRecording(path string) (*recording, error) {
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recording{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *recording) write(e *Exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	if err := r.enc.Encode(e); err != nil {
+		logWarn("recording exchange failed", "url", e.URL, "error", err)
+	}
+}
+
+func (r *recording) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	return r.f.Close()
+}
+
+// recorder appends every exchange made through it to a recording.
+type recorder struct {
+	next http.RoundTripper
+	rec  *recording
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	e := &Exchange{
+		Method: req.Method,
+		URL:    exchangeURL(req.URL),
+	}
+
+	// uploads can be large and are not needed to replay a session
+	if req.Body != nil && req.Method == http.MethodPost {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		e.Operation = operation(body)
+		e.Request = traceSecrets.ReplaceAllString(string(body), "<$1$2>"+secretMask+"</$1>")
+		e.Request = DefaultRedactor.Redact(e.Request)
+	}
+
+	res, err := r.next.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	e.Status = res.StatusCode
+	e.ContentType = res.Header.Get("Content-Type")
+
+	if utf8.Valid(body) {
+		e.Response = DefaultRedactor.Redact(string(body))
+	} else {
+		e.Data = body
+	}
+
+	r.rec.write(e)
+
+	return res, nil
+}
+
+// recordTo makes sc append its exchanges to rec.
+func recordTo(sc *soap.Client, rec *recording) {
+
+	next := sc.Client.Transport
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	sc.Client.Transport = &recorder{next: next, rec: rec}
+}
+
+// CloseRecording closes the file a client created with ClientConfig.Record records to.
+// Later calls of the client are not recorded. It does nothing for other clients.
+func CloseRecording(c *vim25.Client) error {
+
+	if r, ok := c.Client.Client.Transport.(*recorder); ok {
+		return r.rec.Close()
+	}
+
+	return nil
+}
+
+// Replayer serves recorded exchanges back. Requests are matched on method, URL path and
+// SOAP operation; requests with the same key get their responses in recorded order.
+type Replayer struct {
+	mu     sync.Mutex
+	queues map[string][]*Exchange
+	first  *Exchange
+}
+
+// LoadReplay reads exchanges recorded with ClientConfig.Record.
+func LoadReplay(path string) (*Replayer, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return NewReplayer(f)
+}
+
+func NewReplayer(r io.Reader) (*Replayer, error) {
+
+	p := &Replayer{queues: make(map[string][]*Exchange)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		e := new(Exchange)
+
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("replay line %d: %s", line, err)
+		}
+
+		if p.first == nil {
+			p.first = e
+		}
+
+		p.queues[e.key()] = append(p.queues[e.key()], e)
+	}
+
+	return p, scanner.Err()
+}
+
+// URL returns the vCenter URL of the recording.
+func (p *Replayer) URL() string {
+	if p.first == nil {
+		return ""
+	}
+	return p.first.URL
+}
+
+// Remaining returns the number of recorded exchanges not replayed yet.
+func (p *Replayer) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+
+	for _, q := range p.queues {
+		n += len(q)
+	}
+
+	return n
+}
+
+func (p *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	e := &Exchange{
+		Method: req.Method,
+		URL:    exchangeURL(req.URL),
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Method == http.MethodPost {
+			e.Operation = operation(body)
+		}
+	}
+
+	p.mu.Lock()
+	q := p.queues[e.key()]
+
+	if len(q) == 0 {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("replay: no recorded response left for %s", e.key())
+	}
+
+	recorded := q[0]
+	p.queues[e.key()] = q[1:]
+	p.mu.Unlock()
+
+	body := recorded.Data
+
+	if body == nil {
+		body = []byte(recorded.Response)
+	}
+
+	header := make(http.Header)
+
+	if recorded.ContentType != "" {
+		header.Set("Content-Type", recorded.ContentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
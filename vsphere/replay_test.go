@@ -0,0 +1,206 @@
+package vsphere_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+var update = flag.Bool("update", false, "record testdata/getvm.jsonl again from the simulator")
+
+const fixture = "testdata/getvm.jsonl"
+
+// recordGetVM records connecting to the simulator and calling GetVM to path.
+func recordGetVM(t *testing.T, path string) *vsphere.VMInfo {
+	t.Helper()
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	cfg := clientConfig(s)
+	cfg.Record = path
+
+	ctx := context.Background()
+
+	c, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := vsphere.GetVM(ctx, c.Client, "DC0_H0_VM0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vsphere.CloseRecording(c.Client); err != nil {
+		t.Fatal(err)
+	}
+
+	return vm
+}
+
+func checkVM(t *testing.T, vm *vsphere.VMInfo) {
+	t.Helper()
+
+	if vm.VirtualMachine.Summary.Config.Name != "DC0_H0_VM0" || vm.HostSystem.Name != "DC0_H0" ||
+		len(vm.Datastores) != 1 || len(vm.DeviceList) == 0 {
+		t.Errorf("got VM %q on host %q with %d datastores and %d devices", vm.VirtualMachine.Summary.Config.Name,
+			vm.HostSystem.Name, len(vm.Datastores), len(vm.DeviceList))
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+
+	path := filepath.Join(tempDir(t), "session.jsonl")
+
+	recorded := recordGetVM(t, path)
+	checkVM(t, recorded)
+
+	b, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var e vsphere.Exchange
+
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(e.Request, "<password>pass</password>") {
+			t.Errorf("password recorded in %s", e.Operation)
+		}
+	}
+
+	replayer, err := vsphere.LoadReplay(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(replayer.URL())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	sc := soap.NewClient(u, true)
+	sc.Client.Transport = replayer
+
+	c, err := vim25.NewClient(ctx, sc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.NewManager(c).Login(ctx, url.UserPassword("user", "pass")); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := vsphere.GetVM(ctx, c, "DC0_H0_VM0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkVM(t, replayed)
+
+	if n := replayer.Remaining(); n != 0 {
+		t.Errorf("%d recorded exchanges not replayed", n)
+	}
+
+	// nothing left to replay
+	if _, err := methods.GetCurrentTime(ctx, c); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCloseRecording(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	path := filepath.Join(tempDir(t), "session.jsonl")
+
+	cfg := clientConfig(s)
+	cfg.Record = path
+
+	ctx := context.Background()
+
+	c, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vsphere.CloseRecording(c.Client); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client keeps working, unrecorded
+	if _, err := methods.GetCurrentTime(ctx, c.Client); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Error("recorded after CloseRecording")
+	}
+
+	if err := vsphere.CloseRecording(c.Client); err != nil {
+		t.Errorf("closing again: %s", err)
+	}
+
+	// clients that do not record
+	if err := vsphere.CloseRecording(s.Client.Client); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayFixture(t *testing.T) {
+
+	if *update {
+		if err := os.Remove(fixture); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		recordGetVM(t, fixture)
+	}
+
+	ctx := context.Background()
+
+	c, err := vsphere.NewClientWithConfig(ctx, vsphere.ClientConfig{Replay: fixture, Username: "user", Password: "pass"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := vsphere.GetVM(ctx, c.Client, "DC0_H0_VM0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkVM(t, vm)
+}
@@ -0,0 +1,183 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/session/cache"
+	"github.com/vmware/govmomi/session/keepalive"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// login creates a new session on c for the account of cfg; without one c stays anonymous.
+func (cfg ClientConfig) login(ctx context.Context, c *vim25.Client) error {
+
+	if cfg.usesToken() {
+		return cfg.loginByToken(ctx, c)
+	}
+
+	if cfg.Username == "" {
+		return nil
+	}
+
+	return session.NewManager(c).Login(ctx, url.UserPassword(cfg.Username, cfg.Password))
+}
+
+func (cfg ClientConfig) hasAccount() bool {
+	return cfg.Username != "" || cfg.usesToken()
+}
+
+// cachedLogin restores the session saved for u, logging in and saving a new one when it
+// is missing or expired. The returned func saves the session again after a re-login.
+func cachedLogin(ctx context.Context, u *url.URL, cfg ClientConfig) (*vim25.Client, func() error, error) {
+
+	// solution users have no username to key their session by
+	if cfg.Certificate != nil && cfg.Username == "" && len(cfg.Certificate.Certificate) != 0 {
+		uu := *u
+		uu.User = url.User(hashContent(string(cfg.Certificate.Certificate[0])))
+		u = &uu
+	}
+
+	s := &cache.Session{
+		URL:       u,
+		DirSOAP:   cfg.SessionDir,
+		Insecure:  cfg.Insecure,
+		LoginSOAP: cfg.login,
+	}
+
+	vc := new(vim25.Client)
+
+	err := s.Login(ctx, vc, func(sc *soap.Client) error {
+		return configureTransport(sc, cfg)
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return vc, func() error { return s.Save(vc) }, nil
+}
+
+// keepSession wraps the RoundTripper of vc with the keep-alive handler and, for clients
+// with an account, logs in again when a call fails with NotAuthenticated.
+func keepSession(vc *vim25.Client, cfg ClientConfig, save func() error) {
+
+	if !cfg.hasAccount() {
+		return
+	}
+
+	if cfg.KeepAlive > 0 {
+		h := keepalive.NewHandlerSOAP(vc.RoundTripper, cfg.KeepAlive, nil)
+		// the login already happened, so the handler did not see it
+		h.Start()
+		vc.RoundTripper = h
+	}
+
+	vc.RoundTripper = &reloginRoundTripper{
+		RoundTripper: vc.RoundTripper,
+		login: func(ctx context.Context) error {
+			if err := cfg.login(ctx, vc); err != nil {
+				return err
+			}
+			if save != nil {
+				if err := save(); err != nil {
+					logWarn("saving session failed", "url", vc.URL().Host, "error", err)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// stopKeepAlive stops the keep-alive keepSession started for vc, if any, without logging out.
+func stopKeepAlive(vc *vim25.Client) {
+
+	rt, ok := vc.RoundTripper.(*reloginRoundTripper)
+
+	if !ok {
+		return
+	}
+
+	if h, ok := rt.RoundTripper.(*keepalive.HandlerSOAP); ok {
+		h.Stop()
+	}
+}
+
+// reloginRoundTripper retries a call once after logging in again when the session expired.
+type reloginRoundTripper struct {
+	soap.RoundTripper
+
+	login func(context.Context) error
+
+	mu         sync.Mutex
+	generation int64
+}
+
+func (rt *reloginRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+
+	generation := atomic.LoadInt64(&rt.generation)
+
+	err := rt.RoundTripper.RoundTrip(ctx, req, res)
+
+	if !isNotAuthenticated(err) || isSessionRequest(req) {
+		return err
+	}
+
+	if err := rt.relogin(ctx, generation); err != nil {
+		return fmt.Errorf("session expired and logging in again failed: %w", err)
+	}
+
+	// the fault of the first attempt is still set on res
+	if v := reflect.ValueOf(res); v.Kind() == reflect.Ptr && !v.IsNil() {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+	}
+
+	return rt.RoundTripper.RoundTrip(ctx, req, res)
+}
+
+// relogin logs in unless another call already did so since generation was read.
+func (rt *reloginRoundTripper) relogin(ctx context.Context, generation int64) error {
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if atomic.LoadInt64(&rt.generation) != generation {
+		return nil
+	}
+
+	logInfo("session expired, logging in again")
+
+	if err := rt.login(ctx); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&rt.generation, 1)
+
+	return nil
+}
+
+func isNotAuthenticated(err error) bool {
+	switch vimFault(err).(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated:
+		return true
+	}
+
+	return false
+}
+
+func isSessionRequest(req soap.HasFault) bool {
+	switch req.(type) {
+	case *methods.LoginBody, *methods.LoginByTokenBody, *methods.LoginExtensionByCertificateBody, *methods.LogoutBody:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,199 @@
+package vsphere_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func sessionKey(t *testing.T, c *govmomi.Client) string {
+	t.Helper()
+
+	s, err := session.NewManager(c.Client).UserSession(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s == nil {
+		t.Fatal("not logged in")
+	}
+
+	return s.Key
+}
+
+func clientConfig(s *vspheretest.Simulator) vsphere.ClientConfig {
+	u := s.URL()
+	u.User = nil
+
+	return vsphere.ClientConfig{URL: u.String(), Username: "user", Password: "pass"}
+}
+
+func TestSessionCache(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	cfg := clientConfig(s)
+	cfg.SessionCache = true
+	cfg.SessionDir = tempDir(t)
+
+	ctx := context.Background()
+
+	c1, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := ioutil.ReadDir(cfg.SessionDir); len(files) != 1 {
+		t.Fatalf("saved %d sessions, want 1", len(files))
+	}
+
+	c2, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := sessionKey(t, c1)
+
+	if sessionKey(t, c2) != key {
+		t.Error("cached session not reused")
+	}
+
+	// the session expires: c1 logs in again and saves the new session for the next client
+	if err := session.NewManager(c2.Client).Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := methods.GetCurrentTime(ctx, c1.Client); err != nil {
+		t.Fatalf("call after the session expired: %s", err)
+	}
+
+	relogin := sessionKey(t, c1)
+
+	if relogin == key {
+		t.Fatal("no new session")
+	}
+
+	c3, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sessionKey(t, c3) != relogin {
+		t.Error("session of the re-login not saved")
+	}
+}
+
+func TestSessionRelogin(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	c, err := vsphere.NewClientWithConfig(ctx, clientConfig(s))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := sessionKey(t, c)
+
+	// logging out is not retried
+	if err := session.NewManager(c.Client).Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := methods.GetCurrentTime(ctx, c.Client); err != nil {
+		t.Fatalf("call after logging out: %s", err)
+	}
+
+	if sessionKey(t, c) == key {
+		t.Error("still using the session that was logged out")
+	}
+}
+
+// sessionList returns the sessions of the simulator.
+func sessionList(t *testing.T, s *vspheretest.Simulator) []types.UserSession {
+	t.Helper()
+
+	var m mo.SessionManager
+
+	err := property.DefaultCollector(s.Client.Client).RetrieveOne(context.Background(), *s.Client.ServiceContent.SessionManager, []string{"sessionList"}, &m)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m.SessionList
+}
+
+// lastActive returns when the session with key last made a call, as the simulator reports it.
+func lastActive(t *testing.T, s *vspheretest.Simulator, key string) time.Time {
+	t.Helper()
+
+	for _, session := range sessionList(t, s) {
+		if session.Key == key {
+			return session.LastActiveTime
+		}
+	}
+
+	t.Fatalf("no session %s", key)
+
+	return time.Time{}
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+
+	// simulator.SessionIdleTimeout would expire idle sessions, but its session watchers
+	// read it unsynchronized long after a test, so activity is checked instead
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	cfg := clientConfig(s)
+	cfg.KeepAlive = 50 * time.Millisecond
+
+	kept, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logging out stops the keep-alive
+	defer func() { _ = kept.Logout(ctx) }()
+
+	idle, err := vsphere.NewClientWithConfig(ctx, clientConfig(s))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = idle.Logout(ctx) }()
+
+	keptKey, idleKey := sessionKey(t, kept), sessionKey(t, idle)
+
+	start := time.Now()
+
+	time.Sleep(300 * time.Millisecond)
+
+	if !lastActive(t, s, keptKey).After(start) {
+		t.Error("no keep-alive call while idle")
+	}
+
+	if lastActive(t, s, idleKey).After(start) {
+		t.Error("call without keep-alive")
+	}
+}
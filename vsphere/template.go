@@ -0,0 +1,266 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/vmware/govmomi"
+)
+
+// Secret marks a template value that must never be echoed in logs or errors.
+// Its String form is masked; the real value is only substituted into the rendered script.
+type Secret string
+
+func (s Secret) String() string {
+	return secretMask
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", secretMask)
+}
+
+type ScriptTemplate struct {
+	tmpl *template.Template
+}
+
+type RenderedScript struct {
+	Script  string
+	Secrets []string
+}
+
+var scriptTemplateFuncs = template.FuncMap{
+	"ps": plainQuote(quotePowerShell),
+	"sh": plainQuote(quoteShell),
+}
+
+// ParseScriptTemplate parses a text/template script. Missing keys are an error,
+// and the helpers ps and sh quote a value for PowerShell and POSIX shells.
+func ParseScriptTemplate(name, text string) (*ScriptTemplate, error) {
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(scriptTemplateFuncs).Parse(text)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScriptTemplate{tmpl: tmpl}, nil
+}
+
+func (t *ScriptTemplate) Render(data map[string]interface{}) (*RenderedScript, error) {
+
+	rendered := new(RenderedScript)
+
+	plain := unwrapSecrets(data, &rendered.Secrets)
+
+	// a Secret the template can reach would be rendered masked, running the script with the mask
+	if p := findSecret(reflect.ValueOf(plain), "", make(map[uintptr]bool)); p != "" {
+		return nil, fmt.Errorf("template data %s: Secret values are only supported in maps, slices and arrays", strings.TrimPrefix(p, "."))
+	}
+
+	var buf strings.Builder
+
+	if err := t.tmpl.Execute(&buf, plain); err != nil {
+		return nil, rendered.redactError(err)
+	}
+
+	rendered.Script = buf.String()
+
+	return rendered, nil
+}
+
+func (r *RenderedScript) Redact(s string) string {
+	return NewRedactor(r.Secrets...).Redact(s)
+}
+
+func (r *RenderedScript) redactError(err error) error {
+	return NewRedactor(r.Secrets...).Error(err)
+}
+
+func InvokeScriptTemplate(ctx context.Context, c *govmomi.Client, vmName, guestUser, guestPassword string, script string, data map[string]interface{}, options map[string]interface{}) error {
+
+	t, err := ParseScriptTemplate(vmName, script)
+
+	if err != nil {
+		return err
+	}
+
+	rendered, err := t.Render(data)
+
+	if err != nil {
+		return err
+	}
+
+	opts := make(map[string]interface{}, len(options))
+	for k, v := range options {
+		opts[k] = v
+	}
+
+	if o, ok := options["output"].(terraform.UIOutput); ok {
+		opts["output"] = NewRedactor(rendered.Secrets...).Output(o)
+	}
+
+	err = InvokeScript(ctx, c, vmName, guestUser, guestPassword, rendered.Script, opts)
+
+	return rendered.redactError(err)
+}
+
+// unwrapSecrets copies data, replacing Secret values with their plain strings
+// and collecting those strings so they can be masked later.
+func unwrapSecrets(data map[string]interface{}, secrets *[]string) map[string]interface{} {
+
+	plain := make(map[string]interface{}, len(data))
+
+	for k, v := range data {
+		plain[k] = unwrapSecret(v, secrets)
+	}
+
+	return plain
+}
+
+var (
+	secretType = reflect.TypeOf(Secret(""))
+	anyType    = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// unwrapSecret unwraps v when it is a Secret, or the Secrets in the elements of a map,
+// slice or array; slices and arrays are copied to []interface{} and maps to
+// map[K]interface{}. Secrets elsewhere, e.g. in struct fields, are left for findSecret.
+func unwrapSecret(v interface{}, secrets *[]string) interface{} {
+
+	switch v := v.(type) {
+	case Secret:
+		*secrets = append(*secrets, string(v))
+		return string(v)
+	case map[string]interface{}:
+		return unwrapSecrets(v, secrets)
+	}
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		if !mayHoldSecret(rv.Type().Elem()) || (rv.Kind() == reflect.Slice && rv.IsNil()) {
+			return v
+		}
+
+		plain := make([]interface{}, rv.Len())
+
+		for i := range plain {
+			plain[i] = unwrapSecret(rv.Index(i).Interface(), secrets)
+		}
+
+		return plain
+
+	case reflect.Map:
+		if !mayHoldSecret(rv.Type().Elem()) || rv.IsNil() {
+			return v
+		}
+
+		plain := reflect.MakeMapWithSize(reflect.MapOf(rv.Type().Key(), anyType), rv.Len())
+
+		for _, k := range rv.MapKeys() {
+			// a zero Value would delete the key, so nil is set as a nil interface{}
+			e := reflect.New(anyType).Elem()
+
+			if u := unwrapSecret(rv.MapIndex(k).Interface(), secrets); u != nil {
+				e.Set(reflect.ValueOf(u))
+			}
+
+			plain.SetMapIndex(k, e)
+		}
+
+		return plain.Interface()
+	}
+
+	return v
+}
+
+// mayHoldSecret reports whether values of t can be or contain a Secret unwrapSecret replaces.
+func mayHoldSecret(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return mayHoldSecret(t.Elem())
+	}
+	return t == secretType
+}
+
+// findSecret returns the path of a Secret left in v, which templates would render
+// masked, or "" when there is none.
+func findSecret(v reflect.Value, path string, seen map[uintptr]bool) string {
+
+	if !v.IsValid() {
+		return ""
+	}
+
+	if v.Type() == secretType {
+		return path
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		return findSecret(v.Elem(), path, seen)
+
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return ""
+		}
+		seen[v.Pointer()] = true
+		return findSecret(v.Elem(), path, seen)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath == "" {
+				if p := findSecret(v.Field(i), path+"."+f.Name, seen); p != "" {
+					return p
+				}
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if p := findSecret(v.Index(i), fmt.Sprintf("%s[%d]", path, i), seen); p != "" {
+				return p
+			}
+		}
+
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprintf("%s[%v]", path, k)
+
+			if k.Kind() == reflect.String {
+				key = path + "." + k.String()
+			}
+
+			if p := findSecret(v.MapIndex(k), key, seen); p != "" {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
+
+func quotePowerShell(v interface{}) string {
+	return "'" + strings.Replace(fmt.Sprint(v), "'", "''", -1) + "'"
+}
+
+func quoteShell(v interface{}) string {
+	return "'" + strings.Replace(fmt.Sprint(v), "'", `'\''`, -1) + "'"
+}
+
+// plainQuote makes a template helper of quote that fails on a Secret that was not
+// unwrapped, instead of quoting its mask.
+func plainQuote(quote func(interface{}) string) func(interface{}) (string, error) {
+	return func(v interface{}) (string, error) {
+		if _, ok := v.(Secret); ok {
+			return "", fmt.Errorf("cannot quote a Secret that was not unwrapped")
+		}
+		return quote(v), nil
+	}
+}
@@ -0,0 +1,117 @@
+package vsphere_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+func TestInvokeScriptTemplate(t *testing.T) {
+
+	g := new(vspheretest.Guest)
+
+	var script string
+
+	g.Handler = func(p *vspheretest.Process) {
+		data, _ := g.ReadFile(p.Command())
+		script = string(data)
+		p.Stdout = "connecting with s3cr3t"
+	}
+
+	s := vspheretest.Start(t, vspheretest.Options{Guest: g})
+
+	data := map[string]interface{}{
+		"Name":     "O'Brien",
+		"Password": vsphere.Secret("s3cr3t"),
+	}
+
+	var o output
+
+	err := vsphere.InvokeScriptTemplate(context.Background(), s.Client, "DC0_H0_VM0", "admin", "secret",
+		`New-LocalUser -Name {{ps .Name}} -Password {{ps .Password}}`, data, map[string]interface{}{"output": &o})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `New-LocalUser -Name 'O''Brien' -Password 's3cr3t'`; script != want {
+		t.Errorf("guest ran %q, want %q", script, want)
+	}
+
+	if joined := strings.Join(o, ""); strings.Contains(joined, "s3cr3t") || !strings.Contains(joined, "connecting with") {
+		t.Errorf("output not masked: %q", joined)
+	}
+}
+
+func TestScriptTemplateMissingKey(t *testing.T) {
+
+	tmpl, err := vsphere.ParseScriptTemplate("t", `{{.Missing}} {{.Password}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tmpl.Render(map[string]interface{}{"Password": vsphere.Secret("s3cr3t")})
+
+	if err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("got %v, want a masked missing key error", err)
+	}
+}
+
+type credential struct {
+	User     string
+	Password vsphere.Secret
+}
+
+type vault struct{}
+
+func (vault) Password() vsphere.Secret {
+	return "s3cr3t"
+}
+
+func TestScriptTemplateNestedSecrets(t *testing.T) {
+
+	tmpl, err := vsphere.ParseScriptTemplate("t", `{{range .P}}{{ps .}} {{end}}{{range .S}}{{sh .}} {{end}}{{ps .M.db}} {{ps (index .A 1)}} {{ps (index .N 0).K}}`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := tmpl.Render(map[string]interface{}{
+		"P": []interface{}{vsphere.Secret("p1"), "plain"},
+		"S": []vsphere.Secret{"s1"},
+		"M": map[string]vsphere.Secret{"db": "m1"},
+		"A": [2]interface{}{1, vsphere.Secret("a1")},
+		"N": []interface{}{map[string]interface{}{"K": vsphere.Secret("n1")}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `'p1' 'plain' 's1' 'm1' 'a1' 'n1'`; rendered.Script != want {
+		t.Errorf("got %q, want %q", rendered.Script, want)
+	}
+
+	sort.Strings(rendered.Secrets)
+
+	if strings.Join(rendered.Secrets, " ") != "a1 m1 n1 p1 s1" {
+		t.Errorf("got secrets %q", rendered.Secrets)
+	}
+
+	// Secrets that cannot be unwrapped fail instead of rendering the mask
+	tmpl, _ = vsphere.ParseScriptTemplate("t", `{{ps .C.Password}}`)
+
+	if _, err := tmpl.Render(map[string]interface{}{"C": &credential{User: "admin", Password: "s3cr3t"}}); err == nil || !strings.Contains(err.Error(), "C.Password") {
+		t.Errorf("struct field: got %v", err)
+	}
+
+	tmpl, _ = vsphere.ParseScriptTemplate("t", `{{ps .V.Password}}`)
+
+	if r, err := tmpl.Render(map[string]interface{}{"V": vault{}}); err == nil {
+		t.Errorf("method result: rendered %q", r.Script)
+	}
+}
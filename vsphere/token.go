@@ -0,0 +1,85 @@
+package vsphere
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/url"
+
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/sts"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+func (cfg ClientConfig) usesToken() bool {
+	return cfg.Token != "" || cfg.Certificate != nil || cfg.SSOToken
+}
+
+// IssueToken returns the SAML token cfg logs in with: Token when set, otherwise a
+// holder-of-key token for Certificate or a bearer token for Username and Password,
+// issued by the STS of the vCenter c is connected to. Bearer tokens can be passed on as
+// the "samlToken" guest option; holder-of-key tokens only to guest users with an alias
+// for Certificate, see AddGuestAlias.
+func (cfg ClientConfig) IssueToken(ctx context.Context, c *vim25.Client) (string, error) {
+
+	signer, err := cfg.signer(ctx, c)
+
+	if err != nil {
+		return "", err
+	}
+
+	return signer.Token, nil
+}
+
+func (cfg ClientConfig) signer(ctx context.Context, c *vim25.Client) (*sts.Signer, error) {
+
+	if cfg.Token != "" {
+		return &sts.Signer{Token: cfg.Token, Certificate: cfg.Certificate}, nil
+	}
+
+	req := sts.TokenRequest{
+		Certificate: cfg.Certificate,
+		Lifetime:    cfg.TokenLifetime,
+		Delegatable: true,
+	}
+
+	if cfg.Certificate == nil {
+		if cfg.Username == "" {
+			return nil, fmt.Errorf("a token, certificate or username is required to issue a token")
+		}
+		req.Userinfo = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	return issueSigner(ctx, c, req)
+}
+
+// loginByToken logs in to c with the token of cfg, signing the request with Certificate for holder-of-key tokens.
+func (cfg ClientConfig) loginByToken(ctx context.Context, c *vim25.Client) error {
+
+	if cfg.Certificate != nil {
+		c.SetCertificate(*cfg.Certificate)
+	}
+
+	signer, err := cfg.signer(ctx, c)
+
+	if err != nil {
+		return err
+	}
+
+	header := soap.Header{Security: signer}
+
+	return session.NewManager(c).LoginByToken(c.WithHeader(ctx, header))
+}
+
+// LoadSolutionUser reads the PEM certificate and private key of a solution user for ClientConfig.Certificate.
+func LoadSolutionUser(certFile, keyFile string) (*tls.Certificate, error) {
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
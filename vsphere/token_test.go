@@ -0,0 +1,93 @@
+package vsphere_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+
+	_ "github.com/vmware/govmomi/lookup/simulator"
+	_ "github.com/vmware/govmomi/sts/simulator"
+)
+
+func TestIssueToken(t *testing.T) {
+
+	s, u := startTLS(t)
+
+	cfg := vsphere.ClientConfig{URL: u, Username: "user", Password: "pass", Insecure: true}
+
+	token, err := cfg.IssueToken(context.Background(), s.Client.Client)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(token, "Assertion") {
+		t.Errorf("got %q, want a SAML assertion", token)
+	}
+
+	// a given token is passed through
+	cfg.Token = token
+
+	if again, err := cfg.IssueToken(context.Background(), s.Client.Client); err != nil || again != token {
+		t.Errorf("got %v, want the configured token", err)
+	}
+
+	if _, err := (vsphere.ClientConfig{}).IssueToken(context.Background(), s.Client.Client); err == nil {
+		t.Error("issued a token without an account")
+	}
+}
+
+func TestSSOTokenLogin(t *testing.T) {
+
+	_, u := startTLS(t)
+
+	c, err := vsphere.NewClientWithConfig(context.Background(), vsphere.ClientConfig{
+		URL:      u,
+		Username: "user",
+		Password: "pass",
+		Insecure: true,
+		SSOToken: true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionKey(t, c)
+}
+
+func TestSolutionUserSessionCache(t *testing.T) {
+
+	s, u := startTLS(t)
+
+	cfg := vsphere.ClientConfig{
+		URL:          u,
+		Insecure:     true,
+		Certificate:  &s.Server.TLS.Certificates[0],
+		SessionCache: true,
+		SessionDir:   tempDir(t),
+	}
+
+	c1, err := vsphere.NewClientWithConfig(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := vsphere.NewClientWithConfig(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sessionKey(t, c1) != sessionKey(t, c2) {
+		t.Error("cached session of the solution user not reused")
+	}
+
+	if files, _ := ioutil.ReadDir(cfg.SessionDir); len(files) != 1 {
+		t.Errorf("saved %d sessions, want 1", len(files))
+	}
+}
@@ -1,6 +1,7 @@
 package vsphere
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/hashicorp/terraform/terraform"
@@ -11,7 +12,8 @@ import (
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 	"io"
-	"log"
+	"io/ioutil"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -19,6 +21,27 @@ import (
 type ToolBoxClient struct {
 	toolbox.Client
 	AuthMgr *guest.AuthManager
+
+	// VM, VMName and VCenter identify the guest in log lines and audit records.
+	VM      types.ManagedObjectReference
+	VMName  string
+	VCenter string
+
+	// Auditor overrides the package auditor set by SetAuditor.
+	Auditor *Auditor
+
+	// Hooks run around every command, script and upload, see Use.
+	Hooks []GuestHook
+
+	// TempPrefix and TempDir place the guest temp files of this client, so CleanupTempFiles
+	// only touches files it created. They default to DefaultTempPrefix and the guest's temp dir.
+	TempPrefix string
+	TempDir    string
+
+	// DryRun, when set, records commands, scripts and uploads in the plan instead of running them.
+	DryRun *Plan
+
+	acquired bool
 }
 
 type CmdOutput struct {
@@ -26,12 +49,51 @@ type CmdOutput struct {
 	Stderr string
 }
 
-type exitError struct {
-	error
-	exitCode int
+// GuestRunner runs commands and scripts and transfers files in a guest. ToolBoxClient
+// implements it against vCenter; vspheretest.Runner implements it in memory for tests.
+type GuestRunner interface {
+	RunCmd(ctx context.Context, command string, options map[string]interface{}) error
+	RunCmdSync(ctx context.Context, command string) (*CmdOutput, error)
+	RunCmdSyncInput(ctx context.Context, command string, stdin io.Reader) (*CmdOutput, error)
+	RunScript(ctx context.Context, script string, options map[string]interface{}) error
+	UploadFile(ctx context.Context, dst string, f io.Reader, suffix string, isDir bool) error
+	Download(ctx context.Context, src string) (io.ReadCloser, int64, error)
 }
 
-func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[string]interface{}) error {
+var _ GuestRunner = (*ToolBoxClient)(nil)
+
+func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[string]interface{}) (err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(c.VMName, err))
+	}()
+
+	op := &GuestOperation{Kind: "command", VM: c.VMName, Command: command}
+
+	after, err := c.runHooks(ctx, op)
+	if err != nil {
+		return err
+	}
+
+	command = op.Command
+
+	defer func() {
+		after(nil, err)
+	}()
+
+	if c.DryRun != nil {
+		if r, _ := StdinReader(options["stdin"]); r != nil {
+			command = stdinCommand(c.GuestFamily, planStdin, command)
+		}
+		return c.planProgram("command", []string{"-Command", command}, "")
+	}
+
+	rec := c.startAudit("command")
+	rec.SHA256 = hashContent(command)
+
+	defer func() {
+		c.finishAudit(rec, err)
+	}()
 
 	cmdOutput := new(CmdOutput)
 
@@ -49,6 +111,13 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 		return fmt.Errorf(`not able to cast options["output"] terraform.UIOutput`)
 	}
 
+	o = DefaultRedactor.Output(o)
+
+	// output is polled in chunks; pass it on by line so secrets split between polls are masked
+	stdoutLines, stderrLines := &lineOutput{o: o}, &lineOutput{o: o}
+	defer stderrLines.Flush()
+	defer stdoutLines.Flush()
+
 	stdOutPath, err := c.mktemp(ctx)
 
 	if err != nil {
@@ -64,37 +133,34 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 
 	defer c.rm(ctx, stderrPath)
 
-	var args []string
-	if strings.Contains(command,"1>") || strings.Contains(command,"2>") || strings.Contains(command,">") {
-		args = []string{}
+	stdinPath, stdinSize, err := c.uploadStdin(ctx, options["stdin"])
+
+	if err != nil {
+		return err
 	}
-	args = []string{"1>", stdOutPath, "2>", stderrPath}
 
-	var path string
+	rec.BytesUploaded += stdinSize
 
-	switch c.GuestFamily {
-	case types.VirtualMachineGuestOsFamilyWindowsGuest:
-		path = "C:\\WINDOWS\\system32\\WindowsPowerShell\\v1.0\\powershell.exe"
-		args = append([]string{"-Command", fmt.Sprintf(`%s`, command)}, args...)
-	default:
-		//path = "/bin/bash"
-		//arg := "'" + strings.Join(append([]string{cmd.Path}, args...), " ") + "'"
-		//args = []string{"-c", arg}
-		fmt.Errorf("not a windows machine")
+	if stdinPath != "" {
+		defer c.rm(ctx, stdinPath)
+		command = stdinCommand(c.GuestFamily, stdinPath, command)
 	}
 
-	spec := types.GuestProgramSpec{
-		ProgramPath:      path,
-		Arguments:        strings.Join(args, " "),
-		WorkingDirectory: "",
-		EnvVariables:     nil,
+	spec, err := c.programSpec([]string{"-Command", command}, stdOutPath, stderrPath)
+	if err != nil {
+		return err
 	}
+	path := spec.ProgramPath
 
-	pid, err := c.ProcessManager.StartProgram(ctx, c.Authentication, &spec)
+	start := time.Now()
+
+	pid, err := c.ProcessManager.StartProgram(ctx, c.Authentication, spec)
 	if err != nil {
 		return err
 	}
 
+	logDebug("started guest program", "vm", c.VMName, "pid", pid, "path", path, "args", spec.Arguments)
+
 	rc := 0
 	var l = []int64{0, 0} // l[0] - stdoutput len... l[1] - Stderr len
 
@@ -104,7 +170,7 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 
 		if err != nil {
 			if strings.Contains(err.Error(), "agent could not be contacted") {
-				fmt.Println(err.Error())
+				logWarn("guest agent could not be contacted", "vm", c.VMName, "pid", pid, "error", err)
 				return nil
 			}
 			return err
@@ -124,7 +190,7 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 			cmdOutput.Stdout = buf.String()[l[0]:n]
 			l[0] = n
 
-			o.Output(cmdOutput.Stdout)
+			stdoutLines.Output(cmdOutput.Stdout)
 
 			buf, n, err = c.downloadHelperWindows(ctx, stderrPath)
 			if err != nil {
@@ -134,7 +200,7 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 			cmdOutput.Stderr = buf.String()[l[1]:n]
 			l[1] = n
 
-			o.Output(cmdOutput.Stderr)
+			stderrLines.Output(cmdOutput.Stderr)
 			continue
 		}
 
@@ -142,6 +208,10 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 		break
 	}
 
+	logInfo("guest program exited", "vm", c.VMName, "pid", pid, "exitCode", rc, "duration", time.Since(start))
+
+	rec.ExitCode = &rc
+
 	var buf = new(strings.Builder)
 
 	buf, n, err := c.downloadHelperWindows(ctx, stdOutPath)
@@ -149,25 +219,63 @@ func (c ToolBoxClient) RunCmd(ctx context.Context, command string, options map[s
 		return err
 	}
 
+	rec.BytesDownloaded += n
+
 	cmdOutput.Stdout = buf.String()[l[0]:n]
-	o.Output(cmdOutput.Stdout)
+	stdoutLines.Output(cmdOutput.Stdout)
+	stdoutLines.Flush()
 
 	buf, n, err = c.downloadHelperWindows(ctx, stderrPath)
 	if err != nil {
 		return err
 	}
 
+	rec.BytesDownloaded += n
+
 	cmdOutput.Stderr = buf.String()[l[1]:n]
-	o.Output(cmdOutput.Stderr)
+	stderrLines.Output(cmdOutput.Stderr)
+	stderrLines.Flush()
 
 	if rc != 0 {
-		return &exitError{fmt.Errorf("%s: exit %d", path, rc), rc}
+		return NewExitError(path, rc)
 	}
 
 	return nil
 }
 
-func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map[string]interface{}) error{
+func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map[string]interface{}) (err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(c.VMName, err))
+	}()
+
+	op := &GuestOperation{Kind: "script", VM: c.VMName, Command: script}
+
+	after, err := c.runHooks(ctx, op)
+	if err != nil {
+		return err
+	}
+
+	script = op.Command
+
+	defer func() {
+		after(nil, err)
+	}()
+
+	if c.DryRun != nil {
+		stdinPath := ""
+		if r, _ := StdinReader(options["stdin"]); r != nil {
+			stdinPath = planStdin
+		}
+		return c.planProgram("script", c.scriptArgs(planScript, stdinPath), script)
+	}
+
+	rec := c.startAudit("script")
+	rec.SHA256 = hashContent(script)
+
+	defer func() {
+		c.finishAudit(rec, err)
+	}()
 
 	cmdOutput := new(CmdOutput)
 
@@ -185,7 +293,14 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 		return fmt.Errorf(`not able to cast options["output"] terraform.UIOutput`)
 	}
 
-	ExecFile, err := c.FileManager.CreateTemporaryFile(ctx, c.Authentication, "govmomi-", ".ps1", "")
+	o = DefaultRedactor.Output(o)
+
+	// output is polled in chunks; pass it on by line so secrets split between polls are masked
+	stdoutLines, stderrLines := &lineOutput{o: o}, &lineOutput{o: o}
+	defer stderrLines.Flush()
+	defer stdoutLines.Flush()
+
+	ExecFile, err := c.tempFile(ctx, ".ps1")
 	if err != nil {
 		return err
 	}
@@ -201,10 +316,12 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 	err = c.Upload(ctx, readerExecFile, ExecFile, p, &types.GuestFileAttributes{}, true)
 
 	if err != nil {
-		fmt.Println(err)
+		logWarn("uploading script failed", "vm", c.VMName, "path", ExecFile, "error", err)
 		return err
 	}
 
+	rec.BytesUploaded += int64(len(script))
+
 	stdOutPath, err := c.mktemp(ctx)
 
 	if err != nil {
@@ -220,33 +337,33 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 
 	defer c.rm(ctx, stderrPath)
 
-	args := []string{"1>", stdOutPath, "2>", stderrPath}
+	stdinPath, stdinSize, err := c.uploadStdin(ctx, options["stdin"])
 
-	var path string
+	if err != nil {
+		return err
+	}
 
-	switch c.GuestFamily {
-	case types.VirtualMachineGuestOsFamilyWindowsGuest:
-		path = "C:\\WINDOWS\\system32\\WindowsPowerShell\\v1.0\\powershell.exe"
-		args = append([]string{ExecFile}, args...)
-	default:
-		//path = "/bin/bash"
-		//arg := "'" + strings.Join(append([]string{cmd.Path}, args...), " ") + "'"
-		//args = []string{"-c", arg}
-		fmt.Errorf("not a windows machine")
+	rec.BytesUploaded += stdinSize
+
+	if stdinPath != "" {
+		defer c.rm(ctx, stdinPath)
 	}
 
-	spec := types.GuestProgramSpec{
-		ProgramPath:      path,
-		Arguments:        strings.Join(args, " "),
-		WorkingDirectory: "",
-		EnvVariables:     nil,
+	spec, err := c.programSpec(c.scriptArgs(ExecFile, stdinPath), stdOutPath, stderrPath)
+	if err != nil {
+		return err
 	}
+	path := spec.ProgramPath
+
+	start := time.Now()
 
-	pid, err := c.ProcessManager.StartProgram(ctx, c.Authentication, &spec)
+	pid, err := c.ProcessManager.StartProgram(ctx, c.Authentication, spec)
 	if err != nil {
 		return err
 	}
 
+	logDebug("started guest program", "vm", c.VMName, "pid", pid, "path", path, "args", spec.Arguments)
+
 	rc := 0
 	var l = []int64{0, 0} // l[0] - stdoutput len... l[1] - Stderr len
 
@@ -256,7 +373,7 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 
 		if err != nil {
 			if strings.Contains(err.Error(), "agent could not be contacted") {
-				fmt.Println(err.Error())
+				logWarn("guest agent could not be contacted", "vm", c.VMName, "pid", pid, "error", err)
 				return nil
 			}
 			return err
@@ -276,7 +393,7 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 			cmdOutput.Stdout = buf.String()[l[0]:n]
 			l[0] = n
 			
-			o.Output(cmdOutput.Stdout)
+			stdoutLines.Output(cmdOutput.Stdout)
 
 			buf, n, err = c.downloadHelperWindows(ctx, stderrPath)
 			if err != nil {
@@ -286,7 +403,7 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 			cmdOutput.Stderr = buf.String()[l[1]:n]
 			l[1] = n
 
-			o.Output(cmdOutput.Stderr)
+			stderrLines.Output(cmdOutput.Stderr)
 			continue
 		}
 
@@ -294,6 +411,10 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 		break
 	}
 
+	logInfo("guest program exited", "vm", c.VMName, "pid", pid, "exitCode", rc, "duration", time.Since(start))
+
+	rec.ExitCode = &rc
+
 	var buf = new(strings.Builder)
 
 	buf, n, err := c.downloadHelperWindows(ctx, stdOutPath)
@@ -301,24 +422,73 @@ func (c ToolBoxClient) RunScript(ctx context.Context, script string, options map
 		return err
 	}
 
+	rec.BytesDownloaded += n
+
 	cmdOutput.Stdout = buf.String()[l[0]:n]
-	o.Output(cmdOutput.Stdout)
+	stdoutLines.Output(cmdOutput.Stdout)
+	stdoutLines.Flush()
 
 	buf, n, err = c.downloadHelperWindows(ctx, stderrPath)
 	if err != nil {
 		return err
 	}
 
+	rec.BytesDownloaded += n
+
 	cmdOutput.Stderr = buf.String()[l[1]:n]
-	o.Output(cmdOutput.Stderr)
+	stderrLines.Output(cmdOutput.Stderr)
+	stderrLines.Flush()
 
 	if rc != 0 {
-		return &exitError{fmt.Errorf("%s: exit %d", path, rc), rc}
+		return NewExitError(path, rc)
 	}
 	return nil
 }
 
 func (c ToolBoxClient) RunCmdSync(ctx context.Context, command string) (*CmdOutput, error) {
+	return c.RunCmdSyncInput(ctx, command, nil)
+}
+
+// RunCmdSyncInput is RunCmdSync with stdin fed to the command from a guest temp file.
+func (c ToolBoxClient) RunCmdSyncInput(ctx context.Context, command string, stdin io.Reader) (result *CmdOutput, err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(c.VMName, err))
+	}()
+
+	op := &GuestOperation{Kind: "command", VM: c.VMName, Command: command}
+
+	after, err := c.runHooks(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+
+	command = op.Command
+
+	defer func() {
+		after(result, err)
+	}()
+
+	if c.DryRun != nil {
+		if r, _ := StdinReader(stdin); r != nil {
+			command = stdinCommand(c.GuestFamily, planStdin, command)
+		}
+		return new(CmdOutput), c.planProgram("command", syncCommandArgs(command), "")
+	}
+
+	rec := c.startAudit("command")
+	rec.SHA256 = hashContent(command)
+
+	defer func() {
+		c.finishAudit(rec, err)
+	}()
+
+	return c.execCmdSync(ctx, command, stdin, rec)
+}
+
+// execCmdSync runs command without hooks, audit record or dry run, accounting the
+// transfers and exit code in rec. It runs the package's own helper commands.
+func (c ToolBoxClient) execCmdSync(ctx context.Context, command string, stdin io.Reader, rec *AuditRecord) (*CmdOutput, error) {
 
 	stdOutPath, err := c.mktemp(ctx)
 
@@ -335,33 +505,34 @@ func (c ToolBoxClient) RunCmdSync(ctx context.Context, command string) (*CmdOutp
 
 	defer c.rm(ctx, stderrPath)
 
-	args := []string{"1>", stdOutPath, "2>", stderrPath}
+	if stdin != nil {
+		stdinPath, stdinSize, err := c.uploadStdin(ctx, stdin)
 
-	var path string
+		if err != nil {
+			return nil, err
+		}
 
-	switch c.GuestFamily {
-	case types.VirtualMachineGuestOsFamilyWindowsGuest:
-		path = "C:\\WINDOWS\\system32\\WindowsPowerShell\\v1.0\\powershell.exe"
-		args = append([]string{"-Command", fmt.Sprintf(`"& { %s }"`, command)}, args...)
-	default:
-		//path = "/bin/bash"
-		//arg := "'" + strings.Join(append([]string{cmd.Path}, args...), " ") + "'"
-		//args = []string{"-c", arg}
-		fmt.Errorf("not a windows machine")
+		rec.BytesUploaded += stdinSize
+
+		defer c.rm(ctx, stdinPath)
+		command = stdinCommand(c.GuestFamily, stdinPath, command)
 	}
 
-	spec := types.GuestProgramSpec{
-		ProgramPath:      path,
-		Arguments:        strings.Join(args, " "),
-		WorkingDirectory: "",
-		EnvVariables:     nil,
+	spec, err := c.programSpec(syncCommandArgs(command), stdOutPath, stderrPath)
+	if err != nil {
+		return nil, err
 	}
+	path := spec.ProgramPath
 
-	pid, err := c.ProcessManager.StartProgram(ctx, c.Authentication, &spec)
+	start := time.Now()
+
+	pid, err := c.ProcessManager.StartProgram(ctx, c.Authentication, spec)
 	if err != nil {
 		return nil, err
 	}
 
+	logDebug("started guest program", "vm", c.VMName, "pid", pid, "path", path, "args", spec.Arguments)
+
 	rc := 0
 
 	cmdOutput := new(CmdOutput)
@@ -387,24 +558,32 @@ func (c ToolBoxClient) RunCmdSync(ctx context.Context, command string) (*CmdOutp
 		break
 	}
 
+	logInfo("guest program exited", "vm", c.VMName, "pid", pid, "exitCode", rc, "duration", time.Since(start))
+
+	rec.ExitCode = &rc
+
 	var buf = new(strings.Builder)
 
-	buf, _, err = c.downloadHelperWindows(ctx, stdOutPath)
+	buf, n, err := c.downloadHelperWindows(ctx, stdOutPath)
 	if err != nil {
 		return nil, err
 	}
 
+	rec.BytesDownloaded += n
+
 	cmdOutput.Stdout = buf.String()
 
-	buf, _, err = c.downloadHelperWindows(ctx, stderrPath)
+	buf, n, err = c.downloadHelperWindows(ctx, stderrPath)
 	if err != nil {
 		return nil, err
 	}
 
+	rec.BytesDownloaded += n
+
 	cmdOutput.Stderr = buf.String()
 
 	if rc != 0 {
-		return nil, &exitError{fmt.Errorf("%s: exit %d", path, rc), rc}
+		return nil, NewExitError(path, rc)
 	}
 
 	return cmdOutput, nil
@@ -414,32 +593,80 @@ func (c *ToolBoxClient) TestCredentials(ctx context.Context) error {
 	return c.AuthMgr.ValidateCredentials(ctx, c.Authentication)
 }
 
+// ReleaseCredentials releases a ticket obtained through WithAcquiredCredentials.
+// It is a no-op for clients that did not acquire credentials.
+func (c *ToolBoxClient) ReleaseCredentials(ctx context.Context) error {
+	if !c.acquired {
+		return nil
+	}
+
+	if err := c.AuthMgr.ReleaseCredentials(ctx, c.Authentication); err != nil {
+		return err
+	}
+
+	c.acquired = false
+
+	return nil
+}
+
 // customized Function
-func (c *ToolBoxClient) UploadFile(ctx context.Context, dst string, f io.Reader,suffix string, isDir bool) error {
+func (c *ToolBoxClient) UploadFile(ctx context.Context, dst string, f io.Reader,suffix string, isDir bool) (err error) {
 
-	filepath, err := c.FileManager.CreateTemporaryFile(ctx, c.Authentication, "", suffix, "")
+	defer func() {
+		err = DefaultRedactor.Error(guestError(c.VMName, err))
+	}()
+
+	op := &GuestOperation{Kind: "upload", VM: c.VMName, Destination: dst}
+
+	after, err := c.runHooks(ctx, op)
+	if err != nil {
+		return err
+	}
+
+	dst = op.Destination
+
+	defer func() {
+		after(nil, err)
+	}()
+
+	if c.DryRun != nil {
+		return c.planUpload(ctx, dst, isDir)
+	}
+
+	rec := c.startAudit("upload")
+
+	defer func() {
+		c.finishAudit(rec, err)
+	}()
+
+	rec.Destination = dst
+
+	f, p, err := sizedUpload(f)
+	if err != nil {
+		return err
+	}
+
+	filepath, err := c.tempFile(ctx, suffix)
 	if err != nil {
 		return err
 	}
 
 	defer c.FileManager.DeleteFile(ctx, c.Authentication, filepath)
 
-	p := soap.DefaultUpload
 	err = c.Upload(ctx, f, filepath, p, &types.GuestFileAttributes{}, true)
 	if err != nil {
 		return err
 	}
 
-	if isDir {
-
-		if _, err := c.RunCmdSync(ctx, fmt.Sprintf(`mkdir "%s" -Force`, dst)); err != nil {
-			return err
-		}
+	rec.BytesUploaded = p.ContentLength
 
-		cmd := fmt.Sprintf("tar -xzvf %s -C %s", filepath, dst)
+	if isDir {
 
-		if _, err := c.RunCmdSync(ctx, cmd); err != nil {
-			return err
+		// internal steps of the upload, audited and hooked as part of it
+		for _, cmd := range extractCommands(filepath, dst) {
+			if _, err := c.execCmdSync(ctx, cmd, nil, rec); err != nil {
+				return err
+			}
 		}
 
 	} else {
@@ -452,15 +679,223 @@ func (c *ToolBoxClient) UploadFile(ctx context.Context, dst string, f io.Reader,
 	return nil
 }
 
+// Download opens the guest file src for reading. The download is audited when the
+// returned reader is closed, with the bytes read from it.
+func (c *ToolBoxClient) Download(ctx context.Context, src string) (_ io.ReadCloser, _ int64, err error) {
+
+	defer func() {
+		err = DefaultRedactor.Error(guestError(c.VMName, err))
+	}()
+
+	rec := c.startAudit("download")
+	rec.Source = src
+
+	f, n, err := c.Client.Download(ctx, src)
+
+	if err != nil {
+		c.finishAudit(rec, err)
+		return nil, 0, err
+	}
+
+	return &auditedDownload{ReadCloser: f, c: c, rec: rec}, n, nil
+}
+
+// auditedDownload counts the bytes read and writes the audit record of a download on Close.
+type auditedDownload struct {
+	io.ReadCloser
+	c   *ToolBoxClient
+	rec    *AuditRecord
+	err    error
+	closed bool
+}
+
+func (d *auditedDownload) Read(p []byte) (int, error) {
+
+	n, err := d.ReadCloser.Read(p)
+
+	d.rec.BytesDownloaded += int64(n)
+
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+
+	return n, err
+}
+
+func (d *auditedDownload) Close() error {
+
+	err := d.ReadCloser.Close()
+
+	if !d.closed {
+		d.closed = true
+		d.c.finishAudit(d.rec, d.err)
+	}
+
+	return err
+}
+
+// extractCommands returns the commands that create dst and unpack the gzipped tar archive into it.
+func extractCommands(archive, dst string) []string {
+	return []string{
+		fmt.Sprintf(`mkdir "%s" -Force`, dst),
+		fmt.Sprintf("tar -xzvf %s -C %s", archive, dst),
+	}
+}
+
 func (c *ToolBoxClient) rm(ctx context.Context, path string) {
 	err := c.FileManager.DeleteFile(ctx, c.Authentication, path)
 	if err != nil {
-		log.Printf("rm %q: %s", path, err)    // just comment this out
+		logWarn("removing guest file failed", "vm", c.VMName, "path", path, "error", err)
 	}
 }
 
 func (c *ToolBoxClient) mktemp(ctx context.Context) (string, error) {
-	return c.FileManager.CreateTemporaryFile(ctx, c.Authentication, "govmomi-", "", "")
+	return c.tempFile(ctx, "")
+}
+
+// tempFile creates a guest temp file named after TempPrefix in TempDir, see CleanupTempFiles.
+func (c *ToolBoxClient) tempFile(ctx context.Context, suffix string) (string, error) {
+	return c.FileManager.CreateTemporaryFile(ctx, c.Authentication, c.tempPrefix(), suffix, c.TempDir)
+}
+
+// uploadStdin copies the stdin option into a guest temp file and returns its path and size,
+// or "" when no stdin was given. Seekable readers are rewound so they can be replayed.
+func (c *ToolBoxClient) uploadStdin(ctx context.Context, stdin interface{}) (string, int64, error) {
+
+	r, err := StdinReader(stdin)
+
+	if r == nil {
+		return "", 0, err
+	}
+
+	if s, ok := r.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return "", 0, err
+		}
+	}
+
+	r, p, err := sizedUpload(r)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	path, err := c.mktemp(ctx)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	if err := c.Upload(ctx, r, path, p, &types.GuestFileAttributes{}, true); err != nil {
+		c.rm(ctx, path)
+		return "", 0, err
+	}
+
+	return path, p.ContentLength, nil
+}
+
+// stdinCommand redirects the file at stdinPath into command.
+func stdinCommand(family types.VirtualMachineGuestOsFamily, stdinPath, command string) string {
+	switch family {
+	case types.VirtualMachineGuestOsFamilyWindowsGuest:
+		return fmt.Sprintf("Get-Content -LiteralPath %s | %s", quotePowerShell(stdinPath), command)
+	default:
+		return fmt.Sprintf("%s < %s", command, quoteShell(stdinPath))
+	}
+}
+
+const powerShellPath = "C:\\WINDOWS\\system32\\WindowsPowerShell\\v1.0\\powershell.exe"
+
+// programSpec builds the spec that starts args with output redirected to stdoutPath and stderrPath.
+func (c ToolBoxClient) programSpec(args []string, stdoutPath, stderrPath string) (*types.GuestProgramSpec, error) {
+
+	switch c.GuestFamily {
+	case types.VirtualMachineGuestOsFamilyWindowsGuest:
+		args = append(args, "1>", stdoutPath, "2>", stderrPath)
+	default:
+		return nil, fmt.Errorf("not a windows machine")
+	}
+
+	return &types.GuestProgramSpec{
+		ProgramPath: powerShellPath,
+		Arguments:   strings.Join(args, " "),
+	}, nil
+}
+
+func syncCommandArgs(command string) []string {
+	return []string{"-Command", fmt.Sprintf(`"& { %s }"`, command)}
+}
+
+func (c ToolBoxClient) scriptArgs(scriptPath, stdinPath string) []string {
+	if stdinPath != "" {
+		return []string{"-Command", stdinCommand(c.GuestFamily, stdinPath, "& "+quotePowerShell(scriptPath))}
+	}
+	return []string{scriptPath}
+}
+
+// bufferStdin makes the stdin option replayable so every command in a batch receives the same input.
+func bufferStdin(options map[string]interface{}) (map[string]interface{}, error) {
+
+	stdin, present := options["stdin"]
+
+	if !present {
+		return options, nil
+	}
+
+	r, err := StdinReader(stdin)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := make(map[string]interface{}, len(options))
+	for k, v := range options {
+		opts[k] = v
+	}
+
+	// an explicit nil means no stdin
+	if r == nil {
+		delete(opts, "stdin")
+		return opts, nil
+	}
+
+	if _, ok := r.(io.Seeker); ok {
+		return options, nil
+	}
+
+	b, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts["stdin"] = bytes.NewReader(b)
+
+	return opts, nil
+}
+
+// StdinReader returns the reader of a stdin option, or nil when it is unset or a nil value
+// such as a nil *bytes.Reader. GuestRunner fakes use it to treat stdin as ToolBoxClient does.
+func StdinReader(stdin interface{}) (io.Reader, error) {
+
+	if stdin == nil {
+		return nil, nil
+	}
+
+	switch v := reflect.ValueOf(stdin); v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+
+	r, ok := stdin.(io.Reader)
+
+	if !ok {
+		return nil, fmt.Errorf(`not able to cast options["stdin"] io.Reader`)
+	}
+
+	return r, nil
 }
 
 // customized Function
@@ -479,10 +914,11 @@ func adjustEncodingtoWindows(r io.Reader) (io.Reader, error) {
 func (c *ToolBoxClient) downloadHelperWindows(ctx context.Context, path string) (*strings.Builder, int64, error) {
 	temp := new(strings.Builder)
 
-	f, _, err := c.Download(ctx, path)
+	f, _, err := c.Client.Download(ctx, path)
 	if err != nil {
 		return nil, 0, err
 	}
+	defer f.Close()
 
 	z, err := adjustEncodingtoWindows(f)
 	if err != nil {
@@ -497,17 +933,82 @@ func (c *ToolBoxClient) downloadHelperWindows(ctx context.Context, path string)
 	return temp, n, nil
 }
 
-func NewToolBoxClient(ctx context.Context, opsmgr *guest.OperationsManager,guestUser, guestPassword string,family types.VirtualMachineGuestOsFamily) (*ToolBoxClient,error) {
+// ToolBoxOption customizes how NewToolBoxClient authenticates to the guest.
+type ToolBoxOption func(*toolBoxConfig)
 
-	auth := types.NamePasswordAuthentication{
-		GuestAuthentication: types.GuestAuthentication{
-			InteractiveSession: false,
-		},
-		Username: guestUser,
-		Password: guestPassword,
+type toolBoxConfig struct {
+	interactive bool
+	samlToken   string
+	ticket      string
+	acquire     bool
+	sessionID   int64
+}
+
+// WithInteractiveSession runs guest programs in the desktop session of the logged-on user.
+func WithInteractiveSession() ToolBoxOption {
+	return func(cfg *toolBoxConfig) {
+		cfg.interactive = true
+	}
+}
+
+// WithSAMLToken authenticates with a vCenter SSO SAML token instead of a password.
+// The guest user may be left empty when the token is mapped through a guest alias.
+func WithSAMLToken(token string) ToolBoxOption {
+	return func(cfg *toolBoxConfig) {
+		cfg.samlToken = token
 	}
+}
+
+// WithTicketedSession authenticates with a ticket previously returned by AcquireCredentialsInGuest.
+func WithTicketedSession(ticket string) ToolBoxOption {
+	return func(cfg *toolBoxConfig) {
+		cfg.ticket = ticket
+	}
+}
+
+// WithAcquiredCredentials exchanges the configured authentication for a guest ticket
+// up-front, so long sessions do not re-authenticate on every call.
+// The ticket must be released with ReleaseCredentials.
+func WithAcquiredCredentials(sessionID int64) ToolBoxOption {
+	return func(cfg *toolBoxConfig) {
+		cfg.acquire = true
+		cfg.sessionID = sessionID
+	}
+}
+
+func NewToolBoxClient(ctx context.Context, opsmgr *guest.OperationsManager,guestUser, guestPassword string,family types.VirtualMachineGuestOsFamily, opts ...ToolBoxOption) (*ToolBoxClient,error) {
 
-	baseGuestAuth := types.BaseGuestAuthentication(&auth)
+	var cfg toolBoxConfig
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	guestAuth := types.GuestAuthentication{
+		InteractiveSession: cfg.interactive,
+	}
+
+	var baseGuestAuth types.BaseGuestAuthentication
+
+	switch {
+	case cfg.ticket != "":
+		baseGuestAuth = &types.TicketedSessionAuthentication{
+			GuestAuthentication: guestAuth,
+			Ticket:              cfg.ticket,
+		}
+	case cfg.samlToken != "":
+		baseGuestAuth = &types.SAMLTokenAuthentication{
+			GuestAuthentication: guestAuth,
+			Token:               cfg.samlToken,
+			Username:            guestUser,
+		}
+	default:
+		baseGuestAuth = &types.NamePasswordAuthentication{
+			GuestAuthentication: guestAuth,
+			Username:            guestUser,
+			Password:            guestPassword,
+		}
+	}
 
 	pmgr, err := opsmgr.ProcessManager(ctx)
 
@@ -526,6 +1027,13 @@ func NewToolBoxClient(ctx context.Context, opsmgr *guest.OperationsManager,guest
 		return nil, err
 	}
 
+	if cfg.acquire {
+		baseGuestAuth, err = authmgr.AcquireCredentials(ctx, baseGuestAuth, cfg.sessionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &ToolBoxClient{
 		Client:  toolbox.Client{
 			ProcessManager: pmgr,
@@ -534,5 +1042,6 @@ func NewToolBoxClient(ctx context.Context, opsmgr *guest.OperationsManager,guest
 			GuestFamily:    family,
 		},
 		AuthMgr: authmgr,
+		acquired: cfg.acquire,
 	},nil
 }
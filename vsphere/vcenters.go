@@ -0,0 +1,399 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// VCenters holds named vCenter connections. Each connects on first use and is
+// replaced by a fresh connection when a call fails with a connection or session error.
+// It is safe for concurrent use.
+type VCenters struct {
+	// Connect opens a connection, NewClientWithConfig by default.
+	Connect func(ctx context.Context, cfg ClientConfig) (*govmomi.Client, error)
+
+	mu      sync.Mutex
+	entries map[string]*vcenterEntry
+}
+
+type vcenterEntry struct {
+	mu     sync.Mutex
+	cfg    ClientConfig
+	client *govmomi.Client
+}
+
+func NewVCenters() *VCenters {
+	return &VCenters{entries: make(map[string]*vcenterEntry)}
+}
+
+// Register adds or replaces the vCenter called name; it is not contacted until used.
+// The connection of a replaced vCenter is released as Remove does.
+func (r *VCenters) Register(name string, cfg ClientConfig) {
+
+	r.mu.Lock()
+
+	if r.entries == nil {
+		r.entries = make(map[string]*vcenterEntry)
+	}
+
+	old := r.entries[name]
+	r.entries[name] = &vcenterEntry{cfg: cfg}
+
+	r.mu.Unlock()
+
+	if old == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dropLogoutTimeout)
+	defer cancel()
+
+	if err := old.release(ctx); err != nil {
+		logDebug("logging out of the replaced vCenter connection failed", "vcenter", name, "error", err)
+	}
+}
+
+// Remove forgets the vCenter called name and logs out of it if it was connected.
+// Cached sessions stay logged in for later clients; only their keep-alive is stopped.
+func (r *VCenters) Remove(ctx context.Context, name string) error {
+
+	r.mu.Lock()
+	e, ok := r.entries[name]
+	delete(r.entries, name)
+	r.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return e.release(ctx)
+}
+
+// release discards the connection of e, see Remove.
+func (e *vcenterEntry) release(ctx context.Context) error {
+
+	e.mu.Lock()
+	c := e.client
+	e.client = nil
+	cached := e.cfg.SessionCache
+	e.mu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+
+	return releaseClient(ctx, c, cached)
+}
+
+// releaseClient logs c out, or only stops the keep-alive of a cached session.
+func releaseClient(ctx context.Context, c *govmomi.Client, cached bool) error {
+
+	if cached {
+		stopKeepAlive(c.Client)
+		return nil
+	}
+
+	return c.Logout(ctx)
+}
+
+// Names returns the registered vCenters in sorted order.
+func (r *VCenters) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.entries))
+
+	for name := range r.entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Client returns the connection to the vCenter called name, connecting if needed.
+func (r *VCenters) Client(ctx context.Context, name string) (*govmomi.Client, error) {
+
+	e, err := r.entry(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	connect := r.Connect
+
+	if connect == nil {
+		connect = NewClientWithConfig
+	}
+
+	logInfo("connecting to vCenter", "vcenter", name)
+
+	c, err := connect(ctx, e.cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("vCenter %s: %w", name, err)
+	}
+
+	e.client = c
+
+	return c, nil
+}
+
+// Do calls f with the connection to name. When f fails with a connection or session
+// error it is called once more on a new connection.
+func (r *VCenters) Do(ctx context.Context, name string, f func(context.Context, *govmomi.Client) error) error {
+
+	c, err := r.Client(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	err = f(ctx, c)
+
+	if err == nil || !reconnectable(ctx, err) {
+		return err
+	}
+
+	logWarn("vCenter call failed, reconnecting", "vcenter", name, "error", err)
+
+	r.drop(name, c)
+
+	if c, err = r.Client(ctx, name); err != nil {
+		return err
+	}
+
+	return f(ctx, c)
+}
+
+// ForEach calls f for every registered vCenter in parallel, see Do. Failures are
+// returned as VCenterErrors once all calls finished.
+func (r *VCenters) ForEach(ctx context.Context, f func(ctx context.Context, name string, c *govmomi.Client) error) error {
+
+	names := r.Names()
+	errs := make([]error, len(names))
+
+	var wg sync.WaitGroup
+
+	for i, name := range names {
+		wg.Add(1)
+
+		go func(i int, name string) {
+			defer wg.Done()
+
+			errs[i] = r.Do(ctx, name, func(ctx context.Context, c *govmomi.Client) error {
+				return f(ctx, name, c)
+			})
+		}(i, name)
+	}
+
+	wg.Wait()
+
+	failed := make(VCenterErrors)
+
+	for i, err := range errs {
+		if err != nil {
+			failed[names[i]] = err
+		}
+	}
+
+	if len(failed) != 0 {
+		return failed
+	}
+
+	return nil
+}
+
+// VCenterErrors maps vCenter names to the error of the call made to them.
+type VCenterErrors map[string]error
+
+func (e VCenterErrors) Error() string {
+
+	names := make([]string, 0, len(e))
+
+	for name := range e {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %s", name, e[name])
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+type VCenterVirtualMachine struct {
+	VCenter string
+	mo.VirtualMachine
+}
+
+type VCenterHostSystem struct {
+	VCenter string
+	mo.HostSystem
+}
+
+// GetVirtualMachines runs GetVirtualMachines on every vCenter in parallel. Results of the
+// vCenters that answered are returned, in vCenter name order, even when others failed.
+func (r *VCenters) GetVirtualMachines(ctx context.Context, namepattern string) ([]VCenterVirtualMachine, error) {
+
+	var mu sync.Mutex
+	found := make(map[string][]mo.VirtualMachine)
+
+	err := r.ForEach(ctx, func(ctx context.Context, name string, c *govmomi.Client) error {
+		vms, err := GetVirtualMachines(ctx, c.Client, namepattern)
+
+		if isNoMatch(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		found[name] = vms
+		mu.Unlock()
+
+		return nil
+	})
+
+	var result []VCenterVirtualMachine
+
+	for _, name := range r.Names() {
+		for _, vm := range found[name] {
+			result = append(result, VCenterVirtualMachine{VCenter: name, VirtualMachine: vm})
+		}
+	}
+
+	return result, err
+}
+
+// GetHosts runs GetHosts on every vCenter in parallel, like GetVirtualMachines.
+func (r *VCenters) GetHosts(ctx context.Context, namepattern string) ([]VCenterHostSystem, error) {
+
+	var mu sync.Mutex
+	found := make(map[string][]mo.HostSystem)
+
+	err := r.ForEach(ctx, func(ctx context.Context, name string, c *govmomi.Client) error {
+		hosts, err := GetHosts(ctx, c.Client, namepattern)
+
+		if isNoMatch(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		found[name] = hosts
+		mu.Unlock()
+
+		return nil
+	})
+
+	var result []VCenterHostSystem
+
+	for _, name := range r.Names() {
+		for _, host := range found[name] {
+			result = append(result, VCenterHostSystem{VCenter: name, HostSystem: host})
+		}
+	}
+
+	return result, err
+}
+
+func (r *VCenters) entry(name string) (*vcenterEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[name]
+
+	if !ok {
+		return nil, fmt.Errorf("vCenter %s is not registered", name)
+	}
+
+	return e, nil
+}
+
+// dropLogoutTimeout bounds the logout of a connection that is being replaced or re-registered.
+const dropLogoutTimeout = 5 * time.Second
+
+// drop discards c unless another call already replaced it, logging it out so its
+// session does not linger until vCenter expires it. Cached sessions are kept, as
+// Remove does.
+func (r *VCenters) drop(name string, c *govmomi.Client) {
+
+	e, err := r.entry(name)
+
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	dropped := e.client == c
+	if dropped {
+		e.client = nil
+	}
+	cached := e.cfg.SessionCache
+	e.mu.Unlock()
+
+	if !dropped {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dropLogoutTimeout)
+	defer cancel()
+
+	if err := releaseClient(ctx, c, cached); err != nil {
+		logDebug("logging out of the dropped vCenter connection failed", "vcenter", name, "error", err)
+	}
+}
+
+// reconnectable reports whether err is an expired session or a broken connection
+// rather than a fault of the call itself.
+func reconnectable(ctx context.Context, err error) bool {
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if isNotAuthenticated(err) {
+		return true
+	}
+
+	var netErr net.Error
+	var urlErr *url.Error
+
+	return errors.As(err, &netErr) || errors.As(err, &urlErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isNoMatch reports whether err is the error the property collector returns when a
+// name filter matched no object.
+func isNoMatch(err error) bool {
+	return err != nil && err.Error() == "object references is empty"
+}
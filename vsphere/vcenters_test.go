@@ -0,0 +1,289 @@
+package vsphere_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+)
+
+// countingConnect connects with NewClientWithConfig and counts the connections per vCenter.
+type countingConnect struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (cc *countingConnect) connect(ctx context.Context, cfg vsphere.ClientConfig) (*govmomi.Client, error) {
+
+	c, err := vsphere.NewClientWithConfig(ctx, cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.counts == nil {
+		cc.counts = make(map[string]int)
+	}
+
+	cc.counts[cfg.Username]++
+
+	return c, nil
+}
+
+// twoVCenters registers the simulator twice, as vCenters "a" and "b" logging in as different users.
+func twoVCenters(t *testing.T) (*vsphere.VCenters, *countingConnect) {
+	t.Helper()
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	cc := new(countingConnect)
+
+	r := vsphere.NewVCenters()
+	r.Connect = cc.connect
+
+	for _, name := range []string{"b", "a"} {
+		cfg := clientConfig(s)
+		cfg.Username = name
+		r.Register(name, cfg)
+	}
+
+	t.Cleanup(func() {
+		for _, name := range r.Names() {
+			_ = r.Remove(context.Background(), name)
+		}
+	})
+
+	return r, cc
+}
+
+func TestVCentersGetVirtualMachines(t *testing.T) {
+
+	r, cc := twoVCenters(t)
+
+	vms, err := r.GetVirtualMachines(context.Background(), "DC0_H0_*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, vm := range vms {
+		got = append(got, vm.VCenter+"/"+vm.Summary.Config.Name)
+	}
+
+	want := "a/DC0_H0_VM0 a/DC0_H0_VM1 b/DC0_H0_VM0 b/DC0_H0_VM1"
+
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %s", got, want)
+	}
+
+	hosts, err := r.GetHosts(context.Background(), "DC0_H0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hosts) != 2 || hosts[0].VCenter != "a" || hosts[1].VCenter != "b" {
+		t.Errorf("got %d hosts", len(hosts))
+	}
+
+	if cc.counts["a"] != 1 || cc.counts["b"] != 1 {
+		t.Errorf("connected %v times, want once per vCenter", cc.counts)
+	}
+}
+
+func TestVCentersNoMatch(t *testing.T) {
+
+	r, cc := twoVCenters(t)
+
+	vms, err := r.GetVirtualMachines(context.Background(), "no-such-vm")
+
+	if err != nil || len(vms) != 0 {
+		t.Errorf("got %d VMs, %v; want none and no error", len(vms), err)
+	}
+
+	hosts, err := r.GetHosts(context.Background(), "no-such-host")
+
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("got %d hosts, %v; want none and no error", len(hosts), err)
+	}
+
+	if cc.counts["a"] != 1 || cc.counts["b"] != 1 {
+		t.Errorf("connected %v times, want once per vCenter", cc.counts)
+	}
+}
+
+func TestVCentersReconnect(t *testing.T) {
+
+	r, cc := twoVCenters(t)
+
+	ctx := context.Background()
+
+	first, err := r.Client(ctx, "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+
+	err = r.Do(ctx, "a", func(ctx context.Context, c *govmomi.Client) error {
+		calls++
+		if calls == 1 {
+			return io.ErrUnexpectedEOF
+		}
+		if c == first {
+			t.Error("called again with the broken connection")
+		}
+		return nil
+	})
+
+	if err != nil || calls != 2 || cc.counts["a"] != 2 {
+		t.Fatalf("got %v after %d calls and %d connections", err, calls, cc.counts["a"])
+	}
+
+	// the replaced connection was logged out
+	if s, _ := session.NewManager(first.Client).UserSession(ctx); s != nil {
+		t.Error("dropped connection is still logged in")
+	}
+
+	// faults of the call itself do not reconnect
+	err = r.Do(ctx, "a", func(ctx context.Context, c *govmomi.Client) error {
+		_, err := vsphere.GetVirtualMachineDevices(ctx, c.Client, "no-such-vm")
+		return err
+	})
+
+	if err == nil || cc.counts["a"] != 2 {
+		t.Errorf("got %v and %d connections", err, cc.counts["a"])
+	}
+}
+
+func TestVCentersReconnectAfterLogout(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	var connects int
+
+	r := vsphere.NewVCenters()
+
+	// govmomi.NewClient does not log in again by itself, so the expired session reaches Do
+	r.Connect = func(ctx context.Context, cfg vsphere.ClientConfig) (*govmomi.Client, error) {
+		connects++
+		return govmomi.NewClient(ctx, s.URL(), true)
+	}
+
+	r.Register("a", vsphere.ClientConfig{})
+
+	c, err := r.Client(ctx, "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var vms int
+
+	err = r.Do(ctx, "a", func(ctx context.Context, c *govmomi.Client) error {
+		found, err := vsphere.GetVirtualMachines(ctx, c.Client, "*")
+		vms = len(found)
+		return err
+	})
+
+	if err != nil || vms == 0 || connects != 2 {
+		t.Errorf("got %d VMs, %v after %d connections", vms, err, connects)
+	}
+
+	_ = r.Remove(ctx, "a")
+}
+
+func TestVCentersRegisterReplacesConnection(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	r := vsphere.NewVCenters()
+	r.Register("a", clientConfig(s))
+
+	defer r.Remove(ctx, "a")
+
+	first, err := r.Client(ctx, "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := sessionKey(t, first)
+
+	r.Register("a", clientConfig(s))
+
+	for _, session := range sessionList(t, s) {
+		if session.Key == key {
+			t.Error("replaced connection is still logged in")
+		}
+	}
+
+	c, err := r.Client(ctx, "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c == first {
+		t.Error("got the replaced connection")
+	}
+}
+
+func TestVCentersRemoveCachedSession(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	ctx := context.Background()
+
+	cfg := clientConfig(s)
+	cfg.SessionCache = true
+	cfg.SessionDir = tempDir(t)
+	cfg.KeepAlive = 20 * time.Millisecond
+
+	r := vsphere.NewVCenters()
+	r.Register("a", cfg)
+
+	c, err := r.Client(ctx, "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := sessionKey(t, c)
+
+	if err := r.Remove(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a keep-alive already in flight may still land
+	time.Sleep(5 * cfg.KeepAlive)
+
+	// the cached session stays logged in, but is no longer kept alive
+	before := lastActive(t, s, key)
+
+	time.Sleep(10 * cfg.KeepAlive)
+
+	if after := lastActive(t, s, key); after.After(before) {
+		t.Errorf("session active at %s after it was removed at %s", after, before)
+	}
+}
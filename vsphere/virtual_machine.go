@@ -2,7 +2,6 @@ package vsphere
 
 import (
 	"context"
-	"fmt"
 	"github.com/vmware/govmomi/find"
 	"github.com/vmware/govmomi/object"
 	"github.com/vmware/govmomi/property"
@@ -53,7 +52,7 @@ func GetVirtualMachineDevices(ctx context.Context, c *vim25.Client, name string)
 	vm, err := find.NewFinder(c).VirtualMachine(ctx, name)
 
 	if err != nil {
-		return nil, err
+		return nil, vmLookupError(name, err)
 	}
 
 	var vms []mo.VirtualMachine
@@ -70,16 +69,16 @@ func GetVirtualMachineDevices(ctx context.Context, c *vim25.Client, name string)
 			disk := d.(*types.VirtualDisk)
 			info := d.GetVirtualDevice().Backing.(*types.VirtualDiskFlatVer2BackingInfo)
 			// info.EagerlyScrub
-			fmt.Println("[*types.VirtualDisk]", info.Datastore, info.FileName, *info.ThinProvisioned, info.DiskMode, disk.Key, disk.ControllerKey, *disk.UnitNumber, disk.DeviceInfo.GetDescription().Summary, disk.DeviceInfo.GetDescription().Label, disk.CapacityInKB/(1024*1024))
+			logDebug("virtual disk", "vm", name, "datastore", info.Datastore, "file", info.FileName, "thin", *info.ThinProvisioned, "mode", info.DiskMode, "key", disk.Key, "controller", disk.ControllerKey, "unit", *disk.UnitNumber, "summary", disk.DeviceInfo.GetDescription().Summary, "label", disk.DeviceInfo.GetDescription().Label, "capacityGB", disk.CapacityInKB/(1024*1024))
 
 		case *types.VirtualVmxnet3:
 			virtualDevicesFiltered = append(virtualDevicesFiltered, d)
 			vmxnet3Adapter := d.(*types.VirtualVmxnet3)
-			fmt.Println("[*types.VirtualVmxnet3]", vmxnet3Adapter.Connectable.Connected, vmxnet3Adapter.Connectable.StartConnected, vmxnet3Adapter.MacAddress, *vmxnet3Adapter.UnitNumber, vmxnet3Adapter.ControllerKey)
+			logDebug("vmxnet3 adapter", "vm", name, "connected", vmxnet3Adapter.Connectable.Connected, "startConnected", vmxnet3Adapter.Connectable.StartConnected, "mac", vmxnet3Adapter.MacAddress, "unit", *vmxnet3Adapter.UnitNumber, "controller", vmxnet3Adapter.ControllerKey)
 
 		default:
 			//fmt.Println("Neither Network Nor Disk")
-			fmt.Printf("[%v]\n", reflect.TypeOf(d))
+			logDebug("virtual device", "vm", name, "type", reflect.TypeOf(d).String())
 
 		}
 
@@ -103,7 +102,7 @@ func GetVM(ctx context.Context, c *vim25.Client, name string) (*VMInfo, error)
 	vm, err := find.NewFinder(c).VirtualMachine(ctx, name)
 
 	if err != nil {
-		return nil, err
+		return nil, vmLookupError(name, err)
 	}
 
 	vm.Properties(ctx,vm.Reference(),[]string{"summary", "guest", "datastore", "network", "runtime", "guestHeartbeatStatus", "storage", "config"},&vmInfo.VirtualMachine)
@@ -118,16 +117,16 @@ func GetVM(ctx context.Context, c *vim25.Client, name string) (*VMInfo, error)
 			disk := d.(*types.VirtualDisk)
 			info := d.GetVirtualDevice().Backing.(*types.VirtualDiskFlatVer2BackingInfo)
 			// info.EagerlyScrub
-			fmt.Println("[*types.VirtualDisk]", info.Datastore, info.FileName, *info.ThinProvisioned, info.DiskMode, disk.Key, disk.ControllerKey, *disk.UnitNumber, disk.DeviceInfo.GetDescription().Summary, disk.DeviceInfo.GetDescription().Label, disk.CapacityInKB/(1024*1024))
+			logDebug("virtual disk", "vm", name, "datastore", info.Datastore, "file", info.FileName, "thin", *info.ThinProvisioned, "mode", info.DiskMode, "key", disk.Key, "controller", disk.ControllerKey, "unit", *disk.UnitNumber, "summary", disk.DeviceInfo.GetDescription().Summary, "label", disk.DeviceInfo.GetDescription().Label, "capacityGB", disk.CapacityInKB/(1024*1024))
 
 		case *types.VirtualVmxnet3:
 			virtualDevicesFiltered = append(virtualDevicesFiltered, d)
 			vmxnet3Adapter := d.(*types.VirtualVmxnet3)
-			fmt.Println("[*types.VirtualVmxnet3]", vmxnet3Adapter.Connectable.Connected, vmxnet3Adapter.Connectable.StartConnected, vmxnet3Adapter.MacAddress, *vmxnet3Adapter.UnitNumber, vmxnet3Adapter.ControllerKey)
+			logDebug("vmxnet3 adapter", "vm", name, "connected", vmxnet3Adapter.Connectable.Connected, "startConnected", vmxnet3Adapter.Connectable.StartConnected, "mac", vmxnet3Adapter.MacAddress, "unit", *vmxnet3Adapter.UnitNumber, "controller", vmxnet3Adapter.ControllerKey)
 
 		default:
 			//fmt.Println("Neither Network Nor Disk")
-			fmt.Printf("[%v]\n", reflect.TypeOf(d))
+			logDebug("virtual device", "vm", name, "type", reflect.TypeOf(d).String())
 		}
 	}
 
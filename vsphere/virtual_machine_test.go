@@ -0,0 +1,63 @@
+package vsphere_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+	"github.com/roshankarande/go-vsphere/vsphere/vspheretest"
+)
+
+func TestGetVirtualMachines(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	vms, err := vsphere.GetVirtualMachines(context.Background(), s.Client.Client, "DC0_H0_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vms) != 2 {
+		t.Fatalf("got %d VMs, want 2", len(vms))
+	}
+
+	for _, vm := range vms {
+		if vm.Summary.Config.Name == "" || vm.Runtime.Host == nil {
+			t.Errorf("VM %s: properties not retrieved", vm.Self)
+		}
+	}
+}
+
+func TestGetVM(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	info, err := vsphere.GetVM(context.Background(), s.Client.Client, "DC0_H0_VM0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.VirtualMachine.Summary.Config.Name != "DC0_H0_VM0" {
+		t.Errorf("got VM %q", info.VirtualMachine.Summary.Config.Name)
+	}
+
+	if info.HostSystem.Name != "DC0_H0" {
+		t.Errorf("got host %q, want DC0_H0", info.HostSystem.Name)
+	}
+
+	if len(info.Datastores) == 0 {
+		t.Error("no datastores")
+	}
+}
+
+func TestGetVMNotFound(t *testing.T) {
+
+	s := vspheretest.Start(t, vspheretest.Options{})
+
+	_, err := vsphere.GetVM(context.Background(), s.Client.Client, "missing")
+
+	if !errors.Is(err, vsphere.ErrVMNotFound) {
+		t.Errorf("got %v, want a not found error", err)
+	}
+}
@@ -0,0 +1,151 @@
+package vspheretest
+
+import (
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type aliasManager struct {
+	mo.GuestAliasManager
+	guest *Guest
+}
+
+func (m *aliasManager) AddGuestAlias(req *types.AddGuestAlias) soap.HasFault {
+	body := new(methods.AddGuestAliasBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	aliases := g.aliases[req.Username]
+	i := findCert(aliases, req.Base64Cert)
+
+	if i < 0 {
+		aliases = append(aliases, types.GuestAliases{Base64Cert: req.Base64Cert})
+		i = len(aliases) - 1
+	}
+
+	for _, info := range aliases[i].Aliases {
+		if sameSubject(info.Subject, req.AliasInfo.Subject) {
+			body.Fault_ = simulator.Fault("", &types.InvalidArgument{InvalidProperty: "aliasInfo"})
+			return body
+		}
+	}
+
+	aliases[i].Aliases = append(aliases[i].Aliases, req.AliasInfo)
+	g.aliases[req.Username] = aliases
+
+	body.Res = new(types.AddGuestAliasResponse)
+
+	return body
+}
+
+func (m *aliasManager) ListGuestAliases(req *types.ListGuestAliases) soap.HasFault {
+	body := new(methods.ListGuestAliasesBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	body.Res = new(types.ListGuestAliasesResponse)
+
+	// copied, the response is encoded after the lock is released
+	for _, a := range g.aliases[req.Username] {
+		a.Aliases = append([]types.GuestAuthAliasInfo(nil), a.Aliases...)
+		body.Res.Returnval = append(body.Res.Returnval, a)
+	}
+
+	return body
+}
+
+func (m *aliasManager) RemoveGuestAlias(req *types.RemoveGuestAlias) soap.HasFault {
+	body := new(methods.RemoveGuestAliasBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	aliases := g.aliases[req.Username]
+
+	if i := findCert(aliases, req.Base64Cert); i >= 0 {
+		for j, info := range aliases[i].Aliases {
+			if sameSubject(info.Subject, req.Subject) {
+				aliases[i].Aliases = append(aliases[i].Aliases[:j:j], aliases[i].Aliases[j+1:]...)
+
+				if len(aliases[i].Aliases) == 0 {
+					g.aliases[req.Username] = append(aliases[:i:i], aliases[i+1:]...)
+				}
+
+				body.Res = new(types.RemoveGuestAliasResponse)
+				return body
+			}
+		}
+	}
+
+	body.Fault_ = simulator.Fault("", &types.InvalidArgument{InvalidProperty: "subject"})
+
+	return body
+}
+
+func (m *aliasManager) RemoveGuestAliasByCert(req *types.RemoveGuestAliasByCert) soap.HasFault {
+	body := new(methods.RemoveGuestAliasByCertBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	aliases := g.aliases[req.Username]
+
+	if i := findCert(aliases, req.Base64Cert); i >= 0 {
+		g.aliases[req.Username] = append(aliases[:i:i], aliases[i+1:]...)
+	}
+
+	body.Res = new(types.RemoveGuestAliasByCertResponse)
+
+	return body
+}
+
+func findCert(aliases []types.GuestAliases, cert string) int {
+	for i := range aliases {
+		if aliases[i].Base64Cert == cert {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameSubject reports whether a and b are both the any subject or name the same subject.
+func sameSubject(a, b types.BaseGuestAuthSubject) bool {
+
+	an, aNamed := a.(*types.GuestAuthNamedSubject)
+	bn, bNamed := b.(*types.GuestAuthNamedSubject)
+
+	if aNamed || bNamed {
+		return aNamed && bNamed && an.Name == bn.Name
+	}
+
+	return true
+}
@@ -0,0 +1,751 @@
+package vspheretest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// DefaultTempDir is the TEMP directory of the guest unless Guest.Env sets one.
+const DefaultTempDir = `C:\Windows\Temp`
+
+const guestFilePath = "/vspheretest/guestFile"
+
+// Guest fakes the guest process, file, authentication, alias and Windows registry managers.
+// All VMs share one in-memory file system and registry, and started programs run Handler
+// instead of a real process.
+// It is safe for concurrent use.
+type Guest struct {
+	// Username and Password are the accepted credentials; any are accepted when Username is empty.
+	Username string
+	Password string
+
+	// Handler runs a started program by setting its output and exit code; nil exits every
+	// program with 0 and no output. Output is written to the files the program's
+	// arguments redirect it to, UTF-16 encoded like PowerShell does.
+	Handler func(p *Process)
+
+	// Env holds the guest environment variables.
+	Env map[string]string
+
+	scheme string
+
+	mu        sync.Mutex
+	files     map[string]*guestFile
+	dirs      map[string]bool
+	processes []*Process
+	tickets   map[string]int64                // acquired ticket to session ID
+	aliases   map[string][]types.GuestAliases // by guest user
+	registry  map[string]*registryKey         // by key path
+	next      int64
+}
+
+type guestFile struct {
+	data     []byte
+	modified time.Time
+}
+
+// Process is a program started in a guest.
+type Process struct {
+	VM               types.ManagedObjectReference
+	Pid              int64
+	ProgramPath      string
+	Arguments        string
+	WorkingDirectory string
+	Stdin            []byte // content of the file piped to the command, if any
+
+	Auth types.BaseGuestAuthentication // the authentication the program was started with
+
+	Stdout   string
+	Stderr   string
+	ExitCode int32
+
+	Start time.Time
+	End   time.Time
+}
+
+var (
+	redirects = regexp.MustCompile(`\s+1>\s*(\S+)\s+2>\s*(\S+)\s*$`)
+	stdinPipe = regexp.MustCompile(`Get-Content -LiteralPath '((?:[^']|'')*)' \| `)
+)
+
+// Command returns the PowerShell command of the program, without its output redirection.
+func (p *Process) Command() string {
+
+	s := redirects.ReplaceAllString(p.Arguments, "")
+	s = strings.TrimPrefix(s, "-Command ")
+
+	if strings.HasPrefix(s, `"& { `) && strings.HasSuffix(s, ` }"`) {
+		s = s[len(`"& { `) : len(s)-len(` }"`)]
+	}
+
+	return s
+}
+
+func (g *Guest) init() {
+	if g.files == nil {
+		g.files = make(map[string]*guestFile)
+		g.dirs = make(map[string]bool)
+		g.tickets = make(map[string]int64)
+		g.aliases = make(map[string][]types.GuestAliases)
+		g.registry = make(map[string]*registryKey)
+	}
+}
+
+// WriteFile creates or replaces a file in the guest.
+func (g *Guest) WriteFile(path string, data []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.init()
+	g.files[path] = &guestFile{data: append([]byte(nil), data...), modified: time.Now()}
+}
+
+// ReadFile returns the content of a file in the guest.
+func (g *Guest) ReadFile(path string) ([]byte, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.files[path]
+
+	if !ok {
+		return nil, false
+	}
+
+	return append([]byte(nil), f.data...), true
+}
+
+// Touch sets the modification time of a file in the guest, e.g. to make it look stale.
+func (g *Guest) Touch(path string, modified time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.files[path]; ok {
+		f.modified = modified
+	}
+}
+
+// Files returns the paths of all files in the guest, sorted.
+func (g *Guest) Files() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	paths := make([]string, 0, len(g.files))
+
+	for path := range g.files {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Tickets returns the tickets acquired with AcquireCredentialsInGuest and not released
+// yet, with the session ID they were requested for.
+func (g *Guest) Tickets() map[string]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	tickets := make(map[string]int64, len(g.tickets))
+
+	for t, id := range g.tickets {
+		tickets[t] = id
+	}
+
+	return tickets
+}
+
+// Processes returns the programs started so far, in order.
+func (g *Guest) Processes() []Process {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	procs := make([]Process, len(g.processes))
+
+	for i, p := range g.processes {
+		procs[i] = *p
+	}
+
+	return procs
+}
+
+func (g *Guest) env() map[string]string {
+
+	env := map[string]string{"TEMP": DefaultTempDir}
+
+	for k, v := range g.Env {
+		env[k] = v
+	}
+
+	return env
+}
+
+// register replaces the guest managers of the simulator with fakes backed by g.
+func (g *Guest) register(model *simulator.Model) {
+
+	g.init()
+
+	ops := simulator.Map.Get(*model.ServiceContent.GuestOperationsManager).(*simulator.GuestOperationsManager)
+
+	if ops.AuthManager == nil {
+		ops.AuthManager = &types.ManagedObjectReference{Type: "GuestAuthManager", Value: "guestOperationsAuthManager"}
+	}
+
+	auth := &authManager{guest: g}
+	auth.Self = *ops.AuthManager
+	simulator.Map.Put(auth)
+
+	pm := &processManager{guest: g}
+	pm.Self = *ops.ProcessManager
+	simulator.Map.Put(pm)
+
+	fm := &fileManager{guest: g}
+	fm.Self = *ops.FileManager
+	simulator.Map.Put(fm)
+
+	if ops.AliasManager == nil {
+		ops.AliasManager = &types.ManagedObjectReference{Type: "GuestAliasManager", Value: "guestOperationsAliasManager"}
+	}
+
+	am := &aliasManager{guest: g}
+	am.Self = *ops.AliasManager
+	simulator.Map.Put(am)
+
+	if ops.GuestWindowsRegistryManager == nil {
+		ops.GuestWindowsRegistryManager = &types.ManagedObjectReference{Type: "GuestWindowsRegistryManager", Value: "guestOperationsWindowsRegistryManager"}
+	}
+
+	rm := &registryManager{guest: g}
+	rm.Self = *ops.GuestWindowsRegistryManager
+	simulator.Map.Put(rm)
+
+	model.Service.HandleFunc(guestFilePath, g.serveFile)
+
+	guestReady()
+}
+
+// authenticate returns a fault unless auth carries credentials g accepts.
+func (g *Guest) authenticate(auth types.BaseGuestAuthentication) *soap.Fault {
+
+	if g.Username == "" {
+		return nil
+	}
+
+	switch a := auth.(type) {
+	case *types.NamePasswordAuthentication:
+		if a.Username == g.Username && a.Password == g.Password {
+			return nil
+		}
+	case *types.TicketedSessionAuthentication:
+		g.mu.Lock()
+		_, ok := g.tickets[a.Ticket]
+		g.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+	case *types.SAMLTokenAuthentication:
+		if a.Username == g.Username {
+			return nil
+		}
+	}
+
+	return simulator.Fault("", new(types.InvalidGuestLogin))
+}
+
+func fileNotFound(path string) *soap.Fault {
+	return simulator.Fault("", &types.FileNotFound{FileFault: types.FileFault{File: path}})
+}
+
+func fileExists(path string) *soap.Fault {
+	return simulator.Fault("", &types.FileAlreadyExists{FileFault: types.FileFault{File: path}})
+}
+
+func parent(path string) string {
+	if i := strings.LastIndexAny(path, `\/`); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func base(path string) string {
+	return path[strings.LastIndexAny(path, `\/`)+1:]
+}
+
+func cleanDir(path string) string {
+	return strings.TrimRight(path, `\/`)
+}
+
+// isDir reports whether path was created as a directory or holds files; g.mu must be held.
+func (g *Guest) isDir(path string) bool {
+
+	path = cleanDir(path)
+
+	if g.dirs[path] {
+		return true
+	}
+
+	for name := range g.files {
+		if dir := parent(name); dir == path || strings.HasPrefix(dir, path+`\`) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *Guest) serveFile(w http.ResponseWriter, r *http.Request) {
+
+	path := r.URL.Query().Get("path")
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := g.ReadFile(path)
+
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, _ = w.Write(data)
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		g.WriteFile(path, data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *Guest) transferURL(vm types.ManagedObjectReference, path string) string {
+	return (&url.URL{
+		Scheme:   g.scheme,
+		Host:     "*", // replaced with the address of the simulator, see guest.FileManager.TransferURL
+		Path:     guestFilePath,
+		RawQuery: url.Values{"vm": []string{vm.Value}, "path": []string{path}}.Encode(),
+	}).String()
+}
+
+// start runs the program of spec and records it.
+func (g *Guest) start(vm types.ManagedObjectReference, spec types.BaseGuestProgramSpec, auth types.BaseGuestAuthentication) int64 {
+
+	s := spec.GetGuestProgramSpec()
+
+	p := &Process{
+		VM:               vm,
+		ProgramPath:      s.ProgramPath,
+		Arguments:        s.Arguments,
+		WorkingDirectory: s.WorkingDirectory,
+		Auth:             auth,
+		Start:            time.Now(),
+	}
+
+	if m := stdinPipe.FindStringSubmatch(s.Arguments); m != nil {
+		p.Stdin, _ = g.ReadFile(strings.Replace(m[1], "''", "'", -1))
+	}
+
+	if g.Handler != nil {
+		g.Handler(p)
+	}
+
+	p.End = time.Now()
+
+	if m := redirects.FindStringSubmatch(s.Arguments); m != nil {
+		g.WriteFile(m[1], utf16(p.Stdout))
+		g.WriteFile(m[2], utf16(p.Stderr))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next++
+	p.Pid = g.next
+	g.processes = append(g.processes, p)
+
+	return p.Pid
+}
+
+func utf16(s string) []byte {
+	b, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().Bytes([]byte(s))
+	return b
+}
+
+type authManager struct {
+	mo.GuestAuthManager
+	guest *Guest
+}
+
+func (m *authManager) ValidateCredentialsInGuest(req *types.ValidateCredentialsInGuest) soap.HasFault {
+	body := new(methods.ValidateCredentialsInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ == nil {
+		body.Res = new(types.ValidateCredentialsInGuestResponse)
+	}
+
+	return body
+}
+
+func (m *authManager) AcquireCredentialsInGuest(req *types.AcquireCredentialsInGuest) soap.HasFault {
+	body := new(methods.AcquireCredentialsInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.RequestedAuth); body.Fault_ != nil {
+		return body
+	}
+
+	m.guest.mu.Lock()
+	m.guest.next++
+	ticket := "ticket-" + strconv.FormatInt(m.guest.next, 10)
+	m.guest.tickets[ticket] = req.SessionID
+	m.guest.mu.Unlock()
+
+	body.Res = &types.AcquireCredentialsInGuestResponse{
+		Returnval: &types.TicketedSessionAuthentication{Ticket: ticket},
+	}
+
+	return body
+}
+
+func (m *authManager) ReleaseCredentialsInGuest(req *types.ReleaseCredentialsInGuest) soap.HasFault {
+	body := new(methods.ReleaseCredentialsInGuestBody)
+
+	if a, ok := req.Auth.(*types.TicketedSessionAuthentication); ok {
+		m.guest.mu.Lock()
+		delete(m.guest.tickets, a.Ticket)
+		m.guest.mu.Unlock()
+	}
+
+	body.Res = new(types.ReleaseCredentialsInGuestResponse)
+
+	return body
+}
+
+type processManager struct {
+	mo.GuestProcessManager
+	guest *Guest
+}
+
+func (m *processManager) StartProgramInGuest(req *types.StartProgramInGuest) soap.HasFault {
+	body := new(methods.StartProgramInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ == nil {
+		body.Res = &types.StartProgramInGuestResponse{Returnval: m.guest.start(req.Vm, req.Spec, req.Auth)}
+	}
+
+	return body
+}
+
+func (m *processManager) ListProcessesInGuest(req *types.ListProcessesInGuest) soap.HasFault {
+	body := new(methods.ListProcessesInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	body.Res = new(types.ListProcessesInGuestResponse)
+
+	for _, p := range m.guest.Processes() {
+		if len(req.Pids) != 0 && !containsPid(req.Pids, p.Pid) {
+			continue
+		}
+
+		start, end := p.Start, p.End
+
+		body.Res.Returnval = append(body.Res.Returnval, types.GuestProcessInfo{
+			Name:      base(p.ProgramPath),
+			Pid:       p.Pid,
+			CmdLine:   p.ProgramPath + " " + p.Arguments,
+			StartTime: start,
+			EndTime:   &end,
+			ExitCode:  p.ExitCode,
+		})
+	}
+
+	return body
+}
+
+func containsPid(pids []int64, pid int64) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *processManager) TerminateProcessInGuest(req *types.TerminateProcessInGuest) soap.HasFault {
+	body := new(methods.TerminateProcessInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ == nil {
+		body.Res = new(types.TerminateProcessInGuestResponse)
+	}
+
+	return body
+}
+
+func (m *processManager) ReadEnvironmentVariableInGuest(req *types.ReadEnvironmentVariableInGuest) soap.HasFault {
+	body := new(methods.ReadEnvironmentVariableInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	env := m.guest.env()
+	names := req.Names
+
+	if len(names) == 0 {
+		for name := range env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	body.Res = new(types.ReadEnvironmentVariableInGuestResponse)
+
+	for _, name := range names {
+		if v, ok := env[name]; ok {
+			body.Res.Returnval = append(body.Res.Returnval, name+"="+v)
+		}
+	}
+
+	return body
+}
+
+type fileManager struct {
+	mo.GuestFileManager
+	guest *Guest
+}
+
+func (m *fileManager) InitiateFileTransferToGuest(req *types.InitiateFileTransferToGuest) soap.HasFault {
+	body := new(methods.InitiateFileTransferToGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	if _, ok := m.guest.ReadFile(req.GuestFilePath); ok && !req.Overwrite {
+		body.Fault_ = fileExists(req.GuestFilePath)
+		return body
+	}
+
+	body.Res = &types.InitiateFileTransferToGuestResponse{Returnval: m.guest.transferURL(req.Vm, req.GuestFilePath)}
+
+	return body
+}
+
+func (m *fileManager) InitiateFileTransferFromGuest(req *types.InitiateFileTransferFromGuest) soap.HasFault {
+	body := new(methods.InitiateFileTransferFromGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	data, ok := m.guest.ReadFile(req.GuestFilePath)
+
+	if !ok {
+		body.Fault_ = fileNotFound(req.GuestFilePath)
+		return body
+	}
+
+	body.Res = &types.InitiateFileTransferFromGuestResponse{
+		Returnval: types.FileTransferInformation{
+			Attributes: new(types.GuestFileAttributes),
+			Size:       int64(len(data)),
+			Url:        m.guest.transferURL(req.Vm, req.GuestFilePath),
+		},
+	}
+
+	return body
+}
+
+func (m *fileManager) CreateTemporaryFileInGuest(req *types.CreateTemporaryFileInGuest) soap.HasFault {
+	body := new(methods.CreateTemporaryFileInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	dir := req.DirectoryPath
+
+	if dir == "" {
+		dir = m.guest.env()["TEMP"]
+	}
+
+	m.guest.mu.Lock()
+	m.guest.next++
+	path := cleanDir(dir) + `\` + req.Prefix + strconv.FormatInt(m.guest.next, 10) + req.Suffix
+	m.guest.files[path] = &guestFile{modified: time.Now()}
+	m.guest.mu.Unlock()
+
+	body.Res = &types.CreateTemporaryFileInGuestResponse{Returnval: path}
+
+	return body
+}
+
+func (m *fileManager) MakeDirectoryInGuest(req *types.MakeDirectoryInGuest) soap.HasFault {
+	body := new(methods.MakeDirectoryInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	m.guest.mu.Lock()
+	defer m.guest.mu.Unlock()
+
+	for dir := cleanDir(req.DirectoryPath); dir != ""; dir = parent(dir) {
+		m.guest.dirs[dir] = true
+
+		if !req.CreateParentDirectories {
+			break
+		}
+	}
+
+	body.Res = new(types.MakeDirectoryInGuestResponse)
+
+	return body
+}
+
+func (m *fileManager) DeleteFileInGuest(req *types.DeleteFileInGuest) soap.HasFault {
+	body := new(methods.DeleteFileInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	m.guest.mu.Lock()
+	defer m.guest.mu.Unlock()
+
+	if _, ok := m.guest.files[req.FilePath]; !ok {
+		body.Fault_ = fileNotFound(req.FilePath)
+		return body
+	}
+
+	delete(m.guest.files, req.FilePath)
+	body.Res = new(types.DeleteFileInGuestResponse)
+
+	return body
+}
+
+func (m *fileManager) MoveFileInGuest(req *types.MoveFileInGuest) soap.HasFault {
+	body := new(methods.MoveFileInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	m.guest.mu.Lock()
+	defer m.guest.mu.Unlock()
+
+	f, ok := m.guest.files[req.SrcFilePath]
+
+	if !ok {
+		body.Fault_ = fileNotFound(req.SrcFilePath)
+		return body
+	}
+
+	if _, ok := m.guest.files[req.DstFilePath]; ok && !req.Overwrite {
+		body.Fault_ = fileExists(req.DstFilePath)
+		return body
+	}
+
+	delete(m.guest.files, req.SrcFilePath)
+	m.guest.files[req.DstFilePath] = f
+	body.Res = new(types.MoveFileInGuestResponse)
+
+	return body
+}
+
+func (m *fileManager) ListFilesInGuest(req *types.ListFilesInGuest) soap.HasFault {
+	body := new(methods.ListFilesInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	pattern, err := regexp.Compile(req.MatchPattern)
+
+	if err != nil {
+		body.Fault_ = simulator.Fault(err.Error(), &types.InvalidArgument{InvalidProperty: "matchPattern"})
+		return body
+	}
+
+	m.guest.mu.Lock()
+	defer m.guest.mu.Unlock()
+
+	info := func(name, kind string, f *guestFile) types.GuestFileInfo {
+		fi := types.GuestFileInfo{Path: name, Type: kind}
+
+		if f != nil {
+			modified := f.modified
+			fi.Size = int64(len(f.data))
+			fi.Attributes = &types.GuestWindowsFileAttributes{
+				GuestFileAttributes: types.GuestFileAttributes{ModificationTime: &modified},
+			}
+		}
+
+		return fi
+	}
+
+	var files []types.GuestFileInfo
+
+	switch f, ok := m.guest.files[req.FilePath]; {
+	case ok:
+		files = append(files, info(req.FilePath, string(types.GuestFileTypeFile), f))
+	case m.guest.isDir(req.FilePath):
+		dir := cleanDir(req.FilePath)
+
+		for name, f := range m.guest.files {
+			if parent(name) == dir && pattern.MatchString(base(name)) {
+				files = append(files, info(base(name), string(types.GuestFileTypeFile), f))
+			}
+		}
+
+		for name := range m.guest.dirs {
+			if parent(name) == dir && pattern.MatchString(base(name)) {
+				files = append(files, info(base(name), string(types.GuestFileTypeDirectory), nil))
+			}
+		}
+	default:
+		body.Fault_ = fileNotFound(req.FilePath)
+		return body
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	if int(req.Index) < len(files) {
+		files = files[req.Index:]
+	} else {
+		files = nil
+	}
+
+	remaining := 0
+
+	if req.MaxResults > 0 && int(req.MaxResults) < len(files) {
+		remaining = len(files) - int(req.MaxResults)
+		files = files[:req.MaxResults]
+	}
+
+	body.Res = &types.ListFilesInGuestResponse{
+		Returnval: types.GuestListFileInfo{Files: files, Remaining: int32(remaining)},
+	}
+
+	return body
+}
@@ -0,0 +1,292 @@
+package vspheretest
+
+import (
+	"encoding/base64"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+type registryKey struct {
+	values  map[string]types.BaseGuestRegValueDataSpec
+	written time.Time
+}
+
+type registryManager struct {
+	mo.GuestWindowsRegistryManager
+	guest *Guest
+}
+
+func (m *registryManager) CreateRegistryKeyInGuest(req *types.CreateRegistryKeyInGuest) soap.HasFault {
+	body := new(methods.CreateRegistryKeyInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+	path := req.KeyName.RegistryPath
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.registry[path]; ok {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyAlreadyExists{}, path)
+		return body
+	}
+
+	g.registry[path] = &registryKey{values: make(map[string]types.BaseGuestRegValueDataSpec), written: time.Now()}
+
+	body.Res = new(types.CreateRegistryKeyInGuestResponse)
+
+	return body
+}
+
+func (m *registryManager) DeleteRegistryKeyInGuest(req *types.DeleteRegistryKeyInGuest) soap.HasFault {
+	body := new(methods.DeleteRegistryKeyInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+	path := req.KeyName.RegistryPath
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.registry[path]; !ok {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyInvalid{}, path)
+		return body
+	}
+
+	subkeys := g.subkeys(path, true)
+
+	if len(subkeys) != 0 && !req.Recursive {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyHasSubkeys{}, path)
+		return body
+	}
+
+	for _, key := range append(subkeys, path) {
+		delete(g.registry, key)
+	}
+
+	body.Res = new(types.DeleteRegistryKeyInGuestResponse)
+
+	return body
+}
+
+func (m *registryManager) ListRegistryKeysInGuest(req *types.ListRegistryKeysInGuest) soap.HasFault {
+	body := new(methods.ListRegistryKeysInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+	path := req.KeyName.RegistryPath
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.registry[path]; !ok {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyInvalid{}, path)
+		return body
+	}
+
+	pattern, err := regexp.Compile(req.MatchPattern)
+
+	if err != nil {
+		body.Fault_ = simulator.Fault("", &types.InvalidArgument{InvalidProperty: "matchPattern"})
+		return body
+	}
+
+	body.Res = new(types.ListRegistryKeysInGuestResponse)
+
+	for _, key := range g.subkeys(path, req.Recursive) {
+		if !pattern.MatchString(key[strings.LastIndex(key, `\`)+1:]) {
+			continue
+		}
+
+		body.Res.Returnval = append(body.Res.Returnval, types.GuestRegKeyRecordSpec{
+			Key: types.GuestRegKeySpec{
+				KeyName:     types.GuestRegKeyNameSpec{RegistryPath: key, WowBitness: req.KeyName.WowBitness},
+				LastWritten: g.registry[key].written,
+			},
+		})
+	}
+
+	return body
+}
+
+func (m *registryManager) SetRegistryValueInGuest(req *types.SetRegistryValueInGuest) soap.HasFault {
+	body := new(methods.SetRegistryValueInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+	path := req.Value.Name.KeyName.RegistryPath
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key, ok := g.registry[path]
+
+	if !ok {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyInvalid{}, path)
+		return body
+	}
+
+	data := req.Value.Data
+
+	// base64Binary on the wire, which govmomi leaves encoded
+	if b, ok := data.(*types.GuestRegValueBinarySpec); ok {
+		raw, err := base64.StdEncoding.DecodeString(string(b.Value))
+
+		if err != nil {
+			body.Fault_ = simulator.Fault("", &types.InvalidArgument{InvalidProperty: "value"})
+			return body
+		}
+
+		data = &types.GuestRegValueBinarySpec{Value: raw}
+	}
+
+	key.values[req.Value.Name.Name] = data
+	key.written = time.Now()
+
+	body.Res = new(types.SetRegistryValueInGuestResponse)
+
+	return body
+}
+
+func (m *registryManager) ListRegistryValuesInGuest(req *types.ListRegistryValuesInGuest) soap.HasFault {
+	body := new(methods.ListRegistryValuesInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+	path := req.KeyName.RegistryPath
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key, ok := g.registry[path]
+
+	if !ok {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyInvalid{}, path)
+		return body
+	}
+
+	pattern, err := regexp.Compile(req.MatchPattern)
+
+	if err != nil {
+		body.Fault_ = simulator.Fault("", &types.InvalidArgument{InvalidProperty: "matchPattern"})
+		return body
+	}
+
+	names := make([]string, 0, len(key.values))
+
+	for name := range key.values {
+		if pattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	body.Res = new(types.ListRegistryValuesInGuestResponse)
+
+	for _, name := range names {
+		data := key.values[name]
+
+		if b, ok := data.(*types.GuestRegValueBinarySpec); ok {
+			data = &types.GuestRegValueBinarySpec{Value: []byte(base64.StdEncoding.EncodeToString(b.Value))}
+		}
+
+		body.Res.Returnval = append(body.Res.Returnval, types.GuestRegValueSpec{
+			Name: types.GuestRegValueNameSpec{KeyName: req.KeyName, Name: name},
+			Data: data,
+		})
+	}
+
+	return body
+}
+
+func (m *registryManager) DeleteRegistryValueInGuest(req *types.DeleteRegistryValueInGuest) soap.HasFault {
+	body := new(methods.DeleteRegistryValueInGuestBody)
+
+	if body.Fault_ = m.guest.authenticate(req.Auth); body.Fault_ != nil {
+		return body
+	}
+
+	g := m.guest
+	path, name := req.ValueName.KeyName.RegistryPath, req.ValueName.Name
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key, ok := g.registry[path]
+
+	if !ok {
+		body.Fault_ = keyFault(&types.GuestRegistryKeyInvalid{}, path)
+		return body
+	}
+
+	if _, ok := key.values[name]; !ok {
+		body.Fault_ = simulator.Fault("", &types.GuestRegistryValueNotFound{
+			GuestRegistryValueFault: types.GuestRegistryValueFault{KeyName: path, ValueName: name},
+		})
+		return body
+	}
+
+	delete(key.values, name)
+	key.written = time.Now()
+
+	body.Res = new(types.DeleteRegistryValueInGuestResponse)
+
+	return body
+}
+
+// subkeys returns the paths of the subkeys of path, sorted; only its children unless recursive.
+// The caller holds g.mu.
+func (g *Guest) subkeys(path string, recursive bool) []string {
+
+	var keys []string
+
+	for key := range g.registry {
+		if !strings.HasPrefix(key, path+`\`) {
+			continue
+		}
+
+		if recursive || !strings.Contains(key[len(path)+1:], `\`) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// registryKeyFault is one of the GuestRegistryKeyFault types.
+type registryKeyFault interface {
+	types.BaseMethodFault
+	types.BaseGuestRegistryKeyFault
+}
+
+// keyFault returns f as a fault for the key at path.
+func keyFault(f registryKeyFault, path string) *soap.Fault {
+	f.GetGuestRegistryKeyFault().KeyName = path
+	return simulator.Fault("", f)
+}
@@ -0,0 +1,305 @@
+package vspheretest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sync"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/roshankarande/go-vsphere/vsphere"
+)
+
+// Runner is an in-memory vsphere.GuestRunner. Commands and scripts are answered by the
+// first matching expectation, in the order they were added, and every call is recorded.
+// Uploaded files can be downloaded again. It is safe for concurrent use.
+type Runner struct {
+	// Strict fails commands and scripts no expectation matches; otherwise they succeed
+	// without output.
+	Strict bool
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+	files        map[string][]byte
+}
+
+var _ vsphere.GuestRunner = (*Runner)(nil)
+
+// Call is one call made to a Runner.
+type Call struct {
+	Op      string // command, script, upload or download
+	Command string // command or script text
+	Stdin   []byte
+	Path    string // upload destination or download source
+	Data    []byte // uploaded content
+	IsDir   bool
+}
+
+// Expectation is a scripted answer to the commands or scripts it matches.
+type Expectation struct {
+	match    func(string) bool
+	desc     string
+	stdout   string
+	stderr   string
+	exitCode int
+	err      error
+	times    int
+	calls    int
+}
+
+// Expect answers the command or script equal to command.
+func (r *Runner) Expect(command string) *Expectation {
+	return r.add(&Expectation{
+		match: func(s string) bool { return s == command },
+		desc:  fmt.Sprintf("%q", command),
+	})
+}
+
+// ExpectMatch answers the commands and scripts matching pattern.
+func (r *Runner) ExpectMatch(pattern string) *Expectation {
+	re := regexp.MustCompile(pattern)
+
+	return r.add(&Expectation{
+		match: re.MatchString,
+		desc:  fmt.Sprintf("/%s/", pattern),
+	})
+}
+
+func (r *Runner) add(e *Expectation) *Expectation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expectations = append(r.expectations, e)
+
+	return e
+}
+
+// Return sets the output of the matched commands.
+func (e *Expectation) Return(stdout, stderr string) *Expectation {
+	e.stdout, e.stderr = stdout, stderr
+	return e
+}
+
+// Exit makes the matched commands exit with code, returning a *vsphere.ExitError unless it is 0.
+// As with ToolBoxClient, RunCmdSync then returns no output; RunCmd still writes it.
+func (e *Expectation) Exit(code int) *Expectation {
+	e.exitCode = code
+	return e
+}
+
+// Fail makes the matched commands fail with err, as if the guest could not be reached.
+func (e *Expectation) Fail(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times limits the expectation to n matches; by default it matches any number of times.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Calls returns the calls made so far, in order.
+func (r *Runner) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// Unmet describes the expectations that were matched fewer times than set with Times,
+// or never when no count was set.
+func (r *Runner) Unmet() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unmet []string
+
+	for _, e := range r.expectations {
+		switch {
+		case e.times == 0 && e.calls == 0:
+			unmet = append(unmet, fmt.Sprintf("%s was not run", e.desc))
+		case e.times != 0 && e.calls < e.times:
+			unmet = append(unmet, fmt.Sprintf("%s ran %d of %d times", e.desc, e.calls, e.times))
+		}
+	}
+
+	return unmet
+}
+
+// WriteFile makes a file available to Download.
+func (r *Runner) WriteFile(path string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.files == nil {
+		r.files = make(map[string][]byte)
+	}
+
+	r.files[path] = append([]byte(nil), data...)
+}
+
+// ReadFile returns a file uploaded to or written in the runner; directories are kept as
+// the uploaded archive.
+func (r *Runner) ReadFile(path string) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, ok := r.files[path]
+
+	return append([]byte(nil), data...), ok
+}
+
+// run records call and returns the answer of the first expectation matching its command.
+// Like ToolBoxClient.RunCmdSync, it returns no output with an error.
+func (r *Runner) run(call Call) (*vsphere.CmdOutput, error) {
+
+	stdout, stderr, err := r.answer(call)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &vsphere.CmdOutput{Stdout: stdout, Stderr: stderr}, nil
+}
+
+// answer records call and returns the output and error of the first expectation matching
+// its command; the output is kept when the command exits with a non-zero code.
+func (r *Runner) answer(call Call) (stdout, stderr string, err error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, call)
+
+	for _, e := range r.expectations {
+		if e.times != 0 && e.calls >= e.times {
+			continue
+		}
+
+		if !e.match(call.Command) {
+			continue
+		}
+
+		e.calls++
+
+		if e.err != nil {
+			return "", "", e.err
+		}
+
+		if e.exitCode != 0 {
+			return e.stdout, e.stderr, vsphere.NewExitError("powershell.exe", e.exitCode)
+		}
+
+		return e.stdout, e.stderr, nil
+	}
+
+	if r.Strict {
+		return "", "", fmt.Errorf("unexpected %s %q", call.Op, call.Command)
+	}
+
+	return "", "", nil
+}
+
+// readStdin reads a stdin option the way ToolBoxClient does: nil values mean no stdin
+// and seekable readers are read from the start.
+func readStdin(stdin interface{}) ([]byte, error) {
+
+	rd, err := vsphere.StdinReader(stdin)
+
+	if rd == nil {
+		return nil, err
+	}
+
+	if s, ok := rd.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	return ioutil.ReadAll(rd)
+}
+
+// runOutput runs call and writes its output to options["output"] like ToolBoxClient.RunCmd.
+func (r *Runner) runOutput(call Call, options map[string]interface{}) error {
+
+	o, ok := options["output"].(terraform.UIOutput)
+
+	if !ok {
+		return fmt.Errorf("options parameter should have an outputSpec")
+	}
+
+	stdin, err := readStdin(options["stdin"])
+
+	if err != nil {
+		return err
+	}
+
+	call.Stdin = stdin
+
+	stdout, stderr, err := r.answer(call)
+
+	o.Output(stdout)
+	o.Output(stderr)
+
+	return err
+}
+
+func (r *Runner) RunCmd(ctx context.Context, command string, options map[string]interface{}) error {
+	return r.runOutput(Call{Op: "command", Command: command}, options)
+}
+
+func (r *Runner) RunScript(ctx context.Context, script string, options map[string]interface{}) error {
+	return r.runOutput(Call{Op: "script", Command: script}, options)
+}
+
+func (r *Runner) RunCmdSync(ctx context.Context, command string) (*vsphere.CmdOutput, error) {
+	return r.run(Call{Op: "command", Command: command})
+}
+
+func (r *Runner) RunCmdSyncInput(ctx context.Context, command string, stdin io.Reader) (*vsphere.CmdOutput, error) {
+
+	data, err := readStdin(stdin)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.run(Call{Op: "command", Command: command, Stdin: data})
+}
+
+func (r *Runner) UploadFile(ctx context.Context, dst string, f io.Reader, suffix string, isDir bool) error {
+
+	data, err := ioutil.ReadAll(f)
+
+	if err != nil {
+		return err
+	}
+
+	r.WriteFile(dst, data)
+
+	r.mu.Lock()
+	r.calls = append(r.calls, Call{Op: "upload", Path: dst, Data: data, IsDir: isDir})
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *Runner) Download(ctx context.Context, src string) (io.ReadCloser, int64, error) {
+
+	data, ok := r.ReadFile(src)
+
+	r.mu.Lock()
+	r.calls = append(r.calls, Call{Op: "download", Path: src})
+	r.mu.Unlock()
+
+	if !ok {
+		return nil, 0, fmt.Errorf("%s: file not found", src)
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
@@ -0,0 +1,167 @@
+package vspheretest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/roshankarande/go-vsphere/vsphere"
+)
+
+type output []string
+
+func (o *output) Output(s string) {
+	*o = append(*o, s)
+}
+
+func TestRunnerExpectations(t *testing.T) {
+
+	ctx := context.Background()
+
+	r := new(Runner)
+	r.Expect("hostname").Return("web01\n", "")
+	r.ExpectMatch(`^Get-Service `).Return("Running", "").Times(2)
+	r.Expect("exit 2").Return("", "failed").Exit(2)
+
+	out, err := r.RunCmdSync(ctx, "hostname")
+	if err != nil || out.Stdout != "web01\n" {
+		t.Fatalf("hostname: got %+v, %v", out, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if out, err := r.RunCmdSync(ctx, "Get-Service w3svc"); err != nil || out.Stdout != "Running" {
+			t.Fatalf("Get-Service: got %+v, %v", out, err)
+		}
+	}
+
+	// Times is used up, so the command falls through to the default answer
+	if out, err := r.RunCmdSync(ctx, "Get-Service w3svc"); err != nil || out.Stdout != "" {
+		t.Errorf("Get-Service after Times: got %+v, %v", out, err)
+	}
+
+	out, err = r.RunCmdSync(ctx, "exit 2")
+
+	var exit *vsphere.ExitError
+
+	// like ToolBoxClient.RunCmdSync, there is no output with the error
+	if !errors.As(err, &exit) || exit.ExitCode() != 2 || out != nil {
+		t.Errorf("exit 2: got %+v, %v", out, err)
+	}
+
+	// RunCmd writes the output before returning the exit error
+	var o output
+
+	if err := r.RunCmd(ctx, "exit 2", map[string]interface{}{"output": &o}); !errors.As(err, &exit) || strings.Join(o, "|") != "|failed" {
+		t.Errorf("RunCmd exit 2: got output %q, %v", o, err)
+	}
+
+	if unmet := r.Unmet(); len(unmet) != 0 {
+		t.Errorf("unmet expectations: %q", unmet)
+	}
+
+	if n := len(r.Calls()); n != 6 {
+		t.Errorf("recorded %d calls, want 6", n)
+	}
+}
+
+func TestRunnerStrict(t *testing.T) {
+
+	r := &Runner{Strict: true}
+	r.Expect("Get-Date")
+
+	if _, err := r.RunCmdSync(context.Background(), "Remove-Item C:\\"); err == nil {
+		t.Error("unexpected command succeeded")
+	}
+
+	if unmet := r.Unmet(); len(unmet) != 1 {
+		t.Errorf("got unmet %q, want Get-Date", unmet)
+	}
+}
+
+func TestRunnerOutputAndStdin(t *testing.T) {
+
+	r := new(Runner)
+	r.Expect("Write-Host hi").Return("hi", "warning")
+
+	var o output
+
+	err := r.RunScript(context.Background(), "Write-Host hi", map[string]interface{}{
+		"output": &o,
+		"stdin":  strings.NewReader("input"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(o, "|") != "hi|warning" {
+		t.Errorf("got output %q", o)
+	}
+
+	calls := r.Calls()
+
+	if len(calls) != 1 || calls[0].Op != "script" || string(calls[0].Stdin) != "input" {
+		t.Errorf("got calls %+v", calls)
+	}
+
+	if err := r.RunCmd(context.Background(), "Write-Host hi", nil); err == nil {
+		t.Error("RunCmd without output option succeeded")
+	}
+}
+
+func TestRunnerFiles(t *testing.T) {
+
+	ctx := context.Background()
+	r := new(Runner)
+
+	if err := r.UploadFile(ctx, `C:\app\a.txt`, strings.NewReader("abc"), ".txt", false); err != nil {
+		t.Fatal(err)
+	}
+
+	f, n, err := r.Download(ctx, `C:\app\a.txt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadAll(f)
+
+	if string(data) != "abc" || n != 3 {
+		t.Errorf("downloaded %q (%d bytes)", data, n)
+	}
+
+	if _, _, err := r.Download(ctx, `C:\missing`); err == nil {
+		t.Error("downloading a missing file succeeded")
+	}
+}
+
+func TestRunnerStdin(t *testing.T) {
+
+	ctx := context.Background()
+	r := new(Runner)
+
+	// a nil reader is no stdin, as for ToolBoxClient
+	if _, err := r.RunCmdSyncInput(ctx, "Get-Date", (*bytes.Reader)(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.RunCmd(ctx, "Get-Date", map[string]interface{}{"output": new(output), "stdin": (*strings.Reader)(nil)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// seekable readers are read from the start
+	stdin := strings.NewReader("input")
+	_, _ = stdin.ReadByte()
+
+	if _, err := r.RunCmdSyncInput(ctx, "Read-Host", stdin); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := r.Calls()
+
+	if len(calls) != 3 || calls[0].Stdin != nil || calls[1].Stdin != nil || string(calls[2].Stdin) != "input" {
+		t.Errorf("got calls %+v", calls)
+	}
+}
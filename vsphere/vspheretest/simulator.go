@@ -0,0 +1,153 @@
+// Package vspheretest runs govmomi's vCenter simulator in-process, with fake guest
+// operations, for tests of code built on the vsphere package.
+package vspheretest
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Options sizes the simulated inventory; zero fields keep the defaults of simulator.VPX,
+// one datacenter with a standalone host and a cluster of three hosts. VMs are named
+// like DC0_H0_VM0 on standalone hosts and DC0_C0_RP0_VM0 in clusters.
+type Options struct {
+	Datacenters  int
+	Clusters     int // per datacenter
+	ClusterHosts int // per cluster
+	Hosts        int // standalone hosts per datacenter
+	VMs          int // per standalone host and per cluster
+	Datastores   int
+
+	// Model is called before the inventory is created, for settings Options has no field for.
+	Model func(*simulator.Model)
+
+	// Guest fakes the guest operations of every VM; nil uses a Guest that accepts any credentials.
+	Guest *Guest
+
+	// TLS serves https with a self-signed certificate, see simulator.Server.Certificate, and
+	// the lookup service and STS when their simulator packages are imported.
+	TLS bool
+}
+
+// Simulator is a running simulator with a client logged in to it.
+type Simulator struct {
+	Model  *simulator.Model
+	Server *simulator.Server
+	Client *govmomi.Client
+	Guest  *Guest
+}
+
+// New starts a simulator and logs in to it. The simulator inventory is global, so only one
+// Simulator can run at a time.
+func New(ctx context.Context, opts Options) (*Simulator, error) {
+
+	model := simulator.VPX()
+
+	set := func(dst *int, n int) {
+		if n != 0 {
+			*dst = n
+		}
+	}
+
+	set(&model.Datacenter, opts.Datacenters)
+	set(&model.Cluster, opts.Clusters)
+	set(&model.ClusterHost, opts.ClusterHosts)
+	set(&model.Host, opts.Hosts)
+	set(&model.Machine, opts.VMs)
+	set(&model.Datastore, opts.Datastores)
+
+	if opts.Model != nil {
+		opts.Model(model)
+	}
+
+	if err := model.Create(); err != nil {
+		return nil, err
+	}
+
+	g := opts.Guest
+
+	if g == nil {
+		g = new(Guest)
+	}
+
+	g.register(model)
+
+	if opts.TLS {
+		model.Service.TLS = new(tls.Config)
+		model.Service.RegisterEndpoints = true
+	}
+
+	server := model.Service.NewServer()
+	g.scheme = server.URL.Scheme
+
+	c, err := govmomi.NewClient(ctx, server.URL, true)
+
+	if err != nil {
+		server.Close()
+		model.Remove()
+		return nil, err
+	}
+
+	return &Simulator{Model: model, Server: server, Client: c, Guest: g}, nil
+}
+
+// Start is New for tests: it fails t when the simulator does not start and closes it when t finishes.
+func Start(t testing.TB, opts Options) *Simulator {
+	t.Helper()
+
+	s, err := New(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("starting vCenter simulator: %s", err)
+	}
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// URL returns the SDK URL of the simulator, with the credentials it accepts.
+func (s *Simulator) URL() *url.URL {
+	u := *s.Server.URL
+	return &u
+}
+
+// Close logs out and stops the simulator.
+func (s *Simulator) Close() {
+	_ = s.Client.Logout(context.Background())
+	s.Server.Close()
+	s.Model.Remove()
+}
+
+// guestReady makes the guest of every powered on VM look like Windows with VMware Tools running.
+func guestReady() {
+	for _, e := range simulator.Map.All("VirtualMachine") {
+		vm := e.(*simulator.VirtualMachine)
+
+		if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn || vm.Guest == nil {
+			continue
+		}
+
+		vm.Guest.ToolsStatus = types.VirtualMachineToolsStatusToolsOk
+		vm.Guest.ToolsRunningStatus = string(types.VirtualMachineToolsRunningStatusGuestToolsRunning)
+		vm.Guest.GuestFamily = string(types.VirtualMachineGuestOsFamilyWindowsGuest)
+		vm.Guest.GuestOperationsReady = types.NewBool(true)
+		vm.Guest.InteractiveGuestOperationsReady = types.NewBool(true)
+	}
+}
+
+// VirtualMachine returns the simulated VM called name, e.g. to change its guest info, or nil.
+func (s *Simulator) VirtualMachine(name string) *simulator.VirtualMachine {
+	for _, e := range simulator.Map.All("VirtualMachine") {
+		if vm := e.(*simulator.VirtualMachine); vm.Name == name {
+			return vm
+		}
+	}
+	return nil
+}
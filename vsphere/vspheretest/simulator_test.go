@@ -0,0 +1,44 @@
+package vspheretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vmware/govmomi/find"
+)
+
+func TestStartInventory(t *testing.T) {
+
+	s := Start(t, Options{Datacenters: 2, Hosts: 2, VMs: 1})
+
+	ctx := context.Background()
+	finder := find.NewFinder(s.Client.Client)
+
+	dcs, err := finder.DatacenterList(ctx, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dcs) != 2 {
+		t.Errorf("got %d datacenters, want 2", len(dcs))
+	}
+
+	vms, err := finder.VirtualMachineList(ctx, "/DC1/vm/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one per standalone host and one in the cluster
+	if len(vms) != 3 {
+		t.Errorf("got %d VMs in DC1, want 3", len(vms))
+	}
+}
+
+func TestProcessCommand(t *testing.T) {
+
+	p := Process{Arguments: `-Command "& { Get-Date }" 1> C:\out.txt 2> C:\err.txt`}
+
+	if got := p.Command(); got != "Get-Date" {
+		t.Errorf("Command() = %q", got)
+	}
+}